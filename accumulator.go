@@ -17,21 +17,42 @@
 package fortuna
 
 import (
-	"os"
-	"strconv"
-	"strings"
+	"context"
+	"errors"
+	"hash"
+	"log/slog"
 	"sync"
 	"time"
 
-	"github.com/seehuhn/trace"
 	"golang.org/x/crypto/blake2b"
 )
 
+// Default values for the Accumulator settings.  Different values can
+// be chosen using the options of NewAccumulatorWithOptions().
 const (
 	numPools               = 32
 	minPoolSize            = 32
+	maxEventCredit         = 64
 	minReseedInterval      = 100 * time.Millisecond
 	seedFileUpdateInterval = 10 * time.Minute
+	seedFileUpdateReseeds  = 1024
+	seedFileUpdateBytes    = 64 << 20
+)
+
+// poolSize is the size of the state of one entropy pool in bytes.
+// Each pool holds a BLAKE2b-512 hash, chained over all events added
+// to the pool since the last reseed.
+const poolSize = blake2b.Size
+
+var (
+	// ErrNotSeeded is returned by Read() if the Accumulator was
+	// created with the UnseededError policy and has not been seeded
+	// yet.
+	ErrNotSeeded = errors.New("random number generator not seeded yet")
+
+	// ErrClosed is returned when an Accumulator is used after Close()
+	// has been called.
+	ErrClosed = errors.New("random number generator closed")
 )
 
 // Accumulator holds the state of one instance of the Fortuna random
@@ -43,22 +64,66 @@ const (
 // It is safe to access an Accumulator object concurrently from
 // different goroutines.
 type Accumulator struct {
-	seedFile     *os.File
+	cfg *config
+
+	store        SeedStore
+	seedInfo     seedFileData
+	seedKey      *seedKey
+	saveMutex    sync.Mutex
+	saveWake     chan struct{}
 	stopAutoSave chan<- bool
 
 	genMutex sync.Mutex
 	gen      *Generator
-
-	poolMutex    sync.Mutex
-	reseedCount  int
-	nextReseed   time.Time
-	pool         [numPools]blake2b.XOF
-	poolZeroSize int
-
-	sourceMutex sync.Mutex
-	nextSource  uint8
-	stopSources chan bool
-	sources     sync.WaitGroup
+	closed   bool
+	pid      int
+	canary   *forkCanary
+	forks    int
+
+	lastSaved      time.Time
+	unsavedReseeds int
+	unsavedBytes   int64
+	poolReseeded   bool
+	poolSeedSaved  bool
+	autoSaveErr    error
+	autoSaveFails  int
+	saveIdle       bool  // the autosave goroutine is backing off
+	outputBytes    int64 // since the last reseed from the pools
+	totalBytes     int64
+
+	poolMutex   sync.Mutex
+	reseedCount int
+	nextReseed  time.Time
+	poolMem     *lockedBuffer
+	pool        [][]byte
+	poolHash    hash.Hash
+	poolEntropy []float64 // credited entropy in bits
+	poolBytes   []int64
+	lastReseed  time.Time
+
+	seedOnce  sync.Once
+	seeded    chan struct{}
+	poolReady chan struct{}
+
+	sourceMutex    sync.Mutex
+	nextSource     uint64
+	sourceNames    map[string]*source
+	sourceList     []*source // open sources only
+	closedSources  int
+	closedEvents   uint64
+	closedCredit   float64
+	stopSources    chan bool
+	sourcesStopped bool // set by Close(), guarded by sourceMutex
+	sources        sync.WaitGroup
+	healthErr      *HealthError
+	healthFails    int
+
+	eventMutex sync.Mutex
+	events     []Event // queued for the observers, see notify()
+	delivering bool
+
+	seedLog    *slog.Logger
+	entropyLog *slog.Logger
 }
 
 // NewRNG allocates a new instance of the Fortuna random number
@@ -66,16 +131,26 @@ type Accumulator struct {
 //
 // The argument seedFileName gives the name of a file where a small
 // amount of randomness can be stored between runs of the program; the
-// program must be able to both read and write this file.  The
-// contents of the seed file must be kept secret and seed files must
+// program must be able to both read and write this file, and to
+// create files in the containing directory.  The seed file is
+// updated by writing a temporary file and renaming it over the old
+// seed file; concurrent use is prevented by locking a separate file
+// with the suffix ".lock".  The contents of the seed file must be kept secret and seed files must
 // not be shared between concurrently running instances of the random
 // number generator.
 //
 // In case the seed file does not exist, a new seed file is created.
-// If a corrupted seed file is found, ErrCorruptedSeed is returned.
-// If a seed file with insecure file permissions is found,
-// ErrInsecureSeed is returned.  If reading or writing the seed
-// otherwise fails, the corresponding error is returned.
+// If a corrupted seed file is found, an error wrapping
+// ErrCorruptedSeed is returned; seed files written by a newer version
+// of this package give an error wrapping ErrSeedVersion.  If a seed
+// file is insecure, an *InsecureSeedError wrapping ErrInsecureSeed
+// is returned: the seed file must be a regular file (not a symbolic
+// link or a device), must be owned by the effective user ID of the
+// process and must not be accessible by group or others, and the
+// containing directory must not be writable by group or others unless
+// the sticky bit is set.  These checks can be relaxed using
+// WithSeedFilePolicy().  If reading or writing the seed otherwise
+// fails, the corresponding error is returned.
 //
 // The returned random generator must be closed using the .Close()
 // method after use.
@@ -83,173 +158,400 @@ func NewRNG(seedFileName string) (*Accumulator, error) {
 	return NewAccumulator(seedFileName)
 }
 
-
 // NewAccumulator allocates a new instance of the Fortuna random
-// number generator.  The argument 'newCipher' allows to choose a
-// block xof like Serpent or Twofish instead of the default AES.
-// NewAccumulator(aes.NewCipher, seedFileName) is the same as
+// number generator.  NewAccumulator(seedFileName) is the same as
 // NewRNG(seedFileName).  See the documentation for NewRNG() for more
 // information.
 func NewAccumulator(seedFileName string) (*Accumulator, error) {
-	acc := &Accumulator{
-		gen:NewGenerator(),
-	}
-	for i := 0; i < len(acc.pool); i++ {
-		acc.pool[i] = NewXOF()
-	}
-	acc.stopSources = make(chan bool)
+	return NewAccumulatorWithOptions(WithSeedFile(seedFileName))
+}
 
-	if seedFileName != "" {
-		seedFile, err := os.OpenFile(seedFileName,
-			os.O_RDWR|os.O_CREATE|os.O_SYNC, os.FileMode(0600))
+// NewAccumulatorWithOptions allocates a new instance of the Fortuna
+// random number generator, using the given options.  Without options,
+// an Accumulator with the default settings and without a seed file is
+// returned.  If one of the options has an out-of-range value, an
+// error wrapping ErrInvalidOption is returned.  See the documentation
+// for NewRNG() for more information.
+//
+// Before the Accumulator is returned, the underlying primitive is
+// checked against known answers and a reseed cycle is run on a
+// throwaway Accumulator.  If any of these self-tests fails, a
+// *SelfTestError (wrapping ErrSelfTest) is returned.
+func NewAccumulatorWithOptions(opts ...Option) (*Accumulator, error) {
+	cfg := defaultConfig()
+	defer cfg.wipeSeedSecrets()
+	for _, opt := range opts {
+		err := opt(cfg)
 		if err != nil {
 			return nil, err
 		}
-		acc.seedFile = seedFile
+	}
+	if cfg.seedStore != nil && cfg.seedFileName != "" {
+		return nil, invalidOption("WithSeedFile and WithSeedStore cannot be combined")
+	}
+	if cfg.seedKey != nil && cfg.seedPassphrase != nil {
+		return nil, invalidOption("WithSeedKey and WithSeedPassphrase cannot be combined")
+	}
 
-		err = flock(acc.seedFile)
+	genOpts := append([]GeneratorOption{WithGeneratorLogger(cfg.logger)}, cfg.genOpts...)
+	gen, err := newGenerator(genOpts)
+	if err != nil {
+		return nil, err
+	}
+	err = selfTest(gen.prim)
+	if err != nil {
+		gen.mem.free()
+		componentLogger(cfg.logger, "seed").Log(context.Background(),
+			LevelCritical, "self-test failed", logKeyError, err)
+		return nil, err
+	}
+	gen.setInitialSeed()
+	acc := newAccumulator(cfg, gen)
+
+	store := cfg.seedStore
+	if store == nil && cfg.seedFileName != "" {
+		store = NewFileSeedStore(cfg.seedFileName, cfg.seedFilePolicy)
+	}
+	if store != nil {
+		if s, ok := store.(storeLogger); ok {
+			s.setLogger(acc.seedLog)
+		}
+		err = store.Lock()
 		if err != nil {
-			acc.seedFile.Close()
 			return nil, err
 		}
+		acc.store = store
 
 		// The initial seed of the generator depends on the current
 		// time.  This (partially) protects us against old seed files
 		// being restored from backups, etc.
+		acc.saveWake = make(chan struct{}, 1)
 		err = acc.updateSeedFile()
+		acc.flushEvents()
 		if err != nil {
-			acc.seedFile.Close()
+			acc.seedKey.wipe()
+			store.Close()
 			return nil, err
 		}
 
 		quit := make(chan bool)
 		acc.stopAutoSave = quit
-		go func() {
-			ticker := time.Tick(seedFileUpdateInterval)
-			for {
-				select {
-				case <-quit:
-					return
-				case <-ticker:
-					acc.writeSeedFile()
-				}
-			}
-		}()
+		go acc.autoSave(quit)
 	}
 
 	return acc, nil
 }
 
+// newAccumulator allocates a new Accumulator for the given settings
+// and generator.  No seed file is opened.
+func newAccumulator(cfg *config, gen *Generator) *Accumulator {
+	acc := &Accumulator{
+		cfg:         cfg,
+		gen:         gen,
+		poolMem:     newLockedBuffer(cfg.numPools*poolSize, componentLogger(cfg.logger, "memory")),
+		pool:        make([][]byte, cfg.numPools),
+		poolEntropy: make([]float64, cfg.numPools),
+		poolBytes:   make([]int64, cfg.numPools),
+		poolHash:    newPoolHash(),
+		seedLog:     componentLogger(cfg.logger, "seed"),
+		entropyLog:  componentLogger(cfg.logger, "entropy"),
+
+		seeded:    make(chan struct{}),
+		poolReady: make(chan struct{}, 1),
+	}
+	for i := 0; i < len(acc.pool); i++ {
+		acc.pool[i] = acc.poolMem.data[i*poolSize : (i+1)*poolSize : (i+1)*poolSize]
+	}
+	acc.stopSources = make(chan bool)
+	acc.initForkDetection()
+	return acc
+}
+
+func newPoolHash() hash.Hash {
+	h, _ := blake2b.New512(nil)
+	return h
+}
+
 // tearDownPools is called during shutdown of the Accumulator.  The
-// function frees all entropy pools and transfers the remaining
+// function wipes all entropy pools and transfers the remaining
 // entropy into the underlying generator so that it can go into the
 // seed file.
 func (acc *Accumulator) tearDownPools() {
-	const outSize = 64
-	data := make([]byte, numPools*outSize)
+	data := make([]byte, len(acc.poolMem.data))
 
 	acc.poolMutex.Lock()
-	for i := 0; i < numPools; i++ {
-		acc.pool[i].Write(data[i*outSize:i*outSize+outSize])
-		acc.pool[i] = nil
+	copy(data, acc.poolMem.data)
+	acc.poolMem.wipe()
+	wipeHash(acc.poolHash)
+	for i := range acc.pool {
+		acc.poolEntropy[i] = 0 // prevent accidential last-minute reseeding
+		acc.poolBytes[i] = 0
 	}
-	acc.poolZeroSize = 0 // prevent accidential last-minute reseeding
 	acc.poolMutex.Unlock()
 
 	acc.genMutex.Lock()
 	acc.gen.Reseed(data)
 	acc.genMutex.Unlock()
+	wipe(data)
 }
 
 func (acc *Accumulator) tryReseeding() []byte {
-	const outSize = 64
-	now := time.Now()
-
+	now := acc.cfg.clock()
 
 	acc.poolMutex.Lock()
 	defer acc.poolMutex.Unlock()
 
-	if acc.poolZeroSize >= minPoolSize && now.After(acc.nextReseed) {
-		acc.nextReseed = now.Add(minReseedInterval)
-		acc.poolZeroSize = 0
+	if acc.poolEntropy[0] >= acc.cfg.minPoolEntropy && now.After(acc.nextReseed) {
+		acc.nextReseed = now.Add(acc.cfg.minReseedInterval)
+		acc.lastReseed = now
 		acc.reseedCount++
 
-		seed := make([]byte, 0, numPools*outSize)
-		var pools []string
-		cnt := 0
-		for i := uint(0); i < numPools; i++ {
+		seed := make([]byte, 0, len(acc.pool)*poolSize)
+		ev := &ReseedEvent{Count: acc.reseedCount}
+		for i := uint(0); i < uint(len(acc.pool)); i++ {
 			x := 1 << i
 			if acc.reseedCount%x != 0 {
 				break
 			}
 
-			seed = seed[0:cnt*outSize+outSize]
-
-			acc.pool[i].Read(seed[cnt*outSize:cnt*outSize+outSize])
-			acc.pool[i].Reset()
-			pools = append(pools, strconv.Itoa(int(i)))
-			cnt++
+			seed = append(seed, acc.pool[i]...)
+			wipe(acc.pool[i])
+			acc.poolEntropy[i] = 0
+			acc.poolBytes[i] = 0
+			ev.Pools = append(ev.Pools, int(i))
 		}
-		trace.T("fortuna/seed", trace.PrioInfo,
-			"reseeding from pools %s", strings.Join(pools, " "))
+		acc.seedLog.Info("reseeding from pools",
+			logKeyReseed, acc.reseedCount, logKeyPools, ev.Pools)
+		ev.Bytes = len(seed)
+		acc.notify(ev)
 		return seed
 	}
 	return nil
 }
 
-// RandomData returns a slice of n random bytes.  The result can be
-// used as a replacement for a sequence of uniformly distributed and
-// independent bytes, and will be difficult to guess for an attacker.
-func (acc *Accumulator) RandomData(n uint) []byte {
+// reseed mixes seed data from the entropy pools into the generator.
+// The seed is wiped afterwards.  The caller must hold acc.genMutex.
+func (acc *Accumulator) reseed(seed []byte) {
+	defer wipe(seed)
+	acc.gen.Reseed(seed)
+	acc.markSeeded()
+	acc.noteReseed()
+}
+
+// markSeeded records that the generator has received a seed from the
+// entropy pools or from a valid seed file.
+func (acc *Accumulator) markSeeded() {
+	acc.seedOnce.Do(func() {
+		close(acc.seeded)
+	})
+}
+
+// IsSeeded returns true if the generator has been reseeded from the
+// entropy pools at least once, or if a valid seed file has been mixed
+// into the generator state.  Before this happens, the output of the
+// Accumulator only depends on the initial seed, which is derived from
+// the system random number generator and from information about the
+// host and the current time.
+func (acc *Accumulator) IsSeeded() bool {
+	select {
+	case <-acc.seeded:
+		return true
+	default:
+		return false
+	}
+}
+
+// reseedIfReady reseeds the generator if enough entropy has been
+// collected in pool 0.
+func (acc *Accumulator) reseedIfReady() {
+	seed := acc.tryReseeding()
+	if seed != nil {
+		acc.genMutex.Lock()
+		acc.reseed(seed)
+		acc.genMutex.Unlock()
+	}
+	acc.flushEvents()
+}
+
+// WaitSeeded blocks until the Accumulator is seeded (see IsSeeded()),
+// until ctx is cancelled, or until the Accumulator is closed.  In the
+// first case nil is returned, otherwise ctx.Err() or ErrClosed is
+// returned, respectively.
+//
+// Entropy must be submitted via the entropy sinks for the
+// Accumulator to become seeded, unless a valid seed file is used.
+func (acc *Accumulator) WaitSeeded(ctx context.Context) error {
+	for {
+		acc.reseedIfReady()
+		select {
+		case <-acc.seeded:
+			return nil
+		case <-acc.poolReady:
+			// try again
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-acc.stopSources:
+			return ErrClosed
+		}
+	}
+}
+
+// randomData is the common implementation of RandomData() and
+// ReadContext().  The argument policy determines what happens if the
+// Accumulator is not seeded yet.
+func (acc *Accumulator) randomData(ctx context.Context, n uint,
+	policy UnseededPolicy) ([]byte, error) {
+	defer acc.flushEvents()
+
+	switch policy {
+	case UnseededBlock:
+		err := acc.WaitSeeded(ctx)
+		if err != nil {
+			return nil, err
+		}
+	case UnseededError:
+		acc.reseedIfReady()
+		if !acc.IsSeeded() {
+			return nil, ErrNotSeeded
+		}
+	}
+	if acc.cfg.fatalHealthFailures {
+		acc.sourceMutex.Lock()
+		herr := acc.healthErr
+		acc.sourceMutex.Unlock()
+		if herr != nil {
+			return nil, herr
+		}
+	}
+
 	seed := acc.tryReseeding()
 	acc.genMutex.Lock()
 	defer acc.genMutex.Unlock()
+	if acc.closed {
+		wipe(seed)
+		return nil, ErrClosed
+	}
+	acc.checkFork()
 	if seed != nil {
-		acc.gen.Reseed(seed)
+		acc.reseed(seed)
 	}
-	return acc.gen.PseudoRandomData(n)
+	acc.noteOutput(n)
+	return acc.gen.PseudoRandomData(n), nil
+}
+
+// RandomData returns a slice of n random bytes.  The result can be
+// used as a replacement for a sequence of uniformly distributed and
+// independent bytes, and will be difficult to guess for an attacker.
+//
+// If the Accumulator was created with the UnseededBlock or
+// UnseededError policy, RandomData blocks until the Accumulator is
+// seeded.  Since RandomData cannot return errors, it panics if it is
+// called after Close(), or if a health test failed while the
+// WithFatalHealthFailures() option is in effect.  Use ReadContext()
+// to get these conditions reported as errors.
+func (acc *Accumulator) RandomData(n uint) []byte {
+	policy := acc.cfg.unseeded
+	if policy == UnseededError {
+		policy = UnseededBlock
+	}
+	data, err := acc.randomData(context.Background(), n, policy)
+	if err != nil {
+		panic(err)
+	}
+	return data
 }
 
 func (acc *Accumulator) randomDataUnlocked(n uint) []byte {
+	acc.checkFork()
 	seed := acc.tryReseeding()
 	if seed != nil {
-		acc.gen.Reseed(seed)
+		acc.reseed(seed)
 	}
 	return acc.gen.PseudoRandomData(n)
 }
 
 // Read allows to extract randomness from the Accumulator using the
 // io.Reader interface.  Read fills the byte slice p with random
-// bytes.  The method always reads len(p) bytes and never returns an
-// error.
+// bytes.  Read is the same as ReadContext() with a context which is
+// never cancelled.
 func (acc *Accumulator) Read(p []byte) (n int, err error) {
-	copy(p, acc.RandomData(uint(len(p))))
+	return acc.ReadContext(context.Background(), p)
+}
+
+// ReadContext fills the byte slice p with random bytes.  On success,
+// len(p) and nil are returned.  Otherwise no data is read and one of
+// the following errors is returned:
+//
+// ErrClosed is returned if the Accumulator has been closed.
+//
+// If the Accumulator was created with the UnseededBlock policy,
+// ReadContext waits until the Accumulator is seeded; if ctx is
+// cancelled before this happens, ctx.Err() is returned.  With the
+// UnseededError policy, ErrNotSeeded is returned if the Accumulator is
+// not seeded yet.
+//
+// If the WithFatalHealthFailures() option is used, the *HealthError
+// for the first entropy source which failed a health test is
+// returned.
+func (acc *Accumulator) ReadContext(ctx context.Context, p []byte) (int, error) {
+	data, err := acc.randomData(ctx, uint(len(p)), acc.cfg.unseeded)
+	if err != nil {
+		return 0, err
+	}
+	copy(p, data)
+	wipe(data)
 	return len(p), nil
 }
 
 // Close must be called before the program exits to ensure that the
 // seed file is correctly updated.  After Close has been called the
-// Accumulator must not be used any more.
+// Accumulator must not be used any more: Read() and ReadContext()
+// return ErrClosed, and RandomData() panics.  Calling Close() a
+// second time returns ErrClosed.
 func (acc *Accumulator) Close() error {
+	acc.genMutex.Lock()
+	if acc.closed {
+		acc.genMutex.Unlock()
+		return ErrClosed
+	}
+	acc.closed = true
+	acc.genMutex.Unlock()
+
+	acc.sourceMutex.Lock()
+	acc.sourcesStopped = true
+	acc.sourceMutex.Unlock()
 	close(acc.stopSources)
 	acc.sources.Wait()
 
 	acc.tearDownPools()
 
 	var err error
-	if acc.seedFile != nil {
+	if acc.store != nil {
 		acc.stopAutoSave <- true
 		err = acc.writeSeedFile()
-		acc.seedFile.Close()
-		acc.seedFile = nil
+		acc.saveMutex.Lock()
+		closeErr := acc.store.Close()
+		if err == nil {
+			err = closeErr
+		}
+		acc.store = nil
+		acc.seedKey.wipe()
+		acc.saveMutex.Unlock()
 	}
 
-	// Reset the underlying PRNG to ensure that (1) the Accumulator
-	// cannot be used any more after Close() has been called and (2)
-	// information about the key is not retained in memory
-	// indefinitely.
+	// Wipe the key of the underlying PRNG to ensure that (1) the
+	// Accumulator cannot be used any more after Close() has been
+	// called and (2) information about the key is not retained in
+	// memory indefinitely.  The pools have already been wiped by
+	// tearDownPools().  The locked memory itself is released once the
+	// Accumulator is garbage collected.
 	acc.gen.reset()
+	acc.genMutex.Lock()
+	acc.releaseForkDetection()
+	acc.genMutex.Unlock()
 
+	acc.notify(&CloseEvent{Err: err})
+	acc.flushEvents()
 	return err
 }
 
@@ -18,6 +18,7 @@ package fortuna
 
 import (
 	"bytes"
+	"context"
 	"crypto/rand"
 	"io"
 	"io/ioutil"
@@ -43,7 +44,7 @@ func TestAccumulator(t *testing.T) {
 	}
 	out := acc.RandomData(100)
 	correct := []byte{
-		13, 69, 56, 109, 141, 138, 113, 95, 127, 245, 182, 69, 100, 65, 226, 216, 132, 119, 167, 148, 215, 253, 198, 128, 87, 10, 241, 136, 112, 178, 113, 155, 222, 181, 175, 184, 194, 133, 132, 199, 1, 107, 133, 183, 230, 224, 66, 244, 236, 32, 54, 109, 203, 192, 158, 91, 224, 118, 211, 218, 78, 83, 143, 80, 51, 201, 168, 236, 27, 3, 164, 246, 108, 194, 236, 53, 246, 13, 149, 34, 139, 142, 141, 83, 164, 166, 70, 253, 209, 188, 222, 20, 150, 131, 128, 76, 191, 239, 140, 219,
+		226, 137, 25, 100, 0, 113, 168, 207, 67, 209, 244, 193, 77, 122, 174, 107, 25, 190, 19, 178, 203, 14, 241, 154, 185, 155, 147, 249, 238, 65, 28, 33, 125, 100, 4, 25, 183, 221, 235, 181, 255, 142, 68, 206, 211, 30, 15, 226, 185, 176, 149, 219, 33, 207, 233, 126, 168, 206, 89, 176, 114, 193, 171, 9, 57, 0, 105, 147, 79, 125, 142, 190, 204, 122, 97, 207, 170, 28, 59, 173, 134, 195, 226, 245, 6, 229, 123, 175, 56, 138, 145, 236, 240, 143, 95, 57, 130, 35, 167, 50,
 	}
 	if bytes.Compare(out, correct) != 0 {
 		t.Error("wrong RNG output", out)
@@ -53,7 +54,7 @@ func TestAccumulator(t *testing.T) {
 	acc.addRandomEvent(0, 0, make([]byte, 32))
 	out = acc.RandomData(100)
 	correct = []byte{
-		128, 162, 173, 80, 167, 236, 238, 209, 71, 236, 33, 191, 219, 242, 120, 92, 208, 184, 72, 88, 46, 135, 206, 130, 188, 131, 124, 210, 219, 53, 206, 134, 132, 142, 45, 140, 168, 163, 97, 170, 59, 129, 59, 49, 179, 210, 18, 240, 22, 66, 151, 200, 62, 48, 127, 167, 99, 4, 137, 110, 58, 255, 195, 55, 129, 74, 53, 217, 179, 219, 72, 41, 208, 148, 210, 159, 108, 140, 216, 32, 36, 213, 29, 183, 151, 216, 143, 134, 193, 227, 31, 125, 71, 196, 232, 2, 27, 90, 99, 36,
+		111, 39, 46, 89, 169, 139, 115, 201, 104, 253, 120, 244, 156, 33, 111, 181, 34, 188, 45, 52, 237, 53, 148, 113, 207, 6, 121, 222, 251, 216, 153, 72, 35, 231, 118, 77, 218, 139, 86, 113, 164, 216, 248, 185, 211, 108, 46, 1, 82, 242, 117, 175, 109, 85, 129, 168, 25, 103, 99, 171, 161, 94, 116, 10, 90, 224, 4, 141, 141, 123, 216, 140, 251, 223, 95, 250, 139, 124, 96, 140, 193, 185, 176, 153, 201, 217, 203, 161, 86, 174, 212, 195, 87, 76, 16, 22, 251, 238, 137, 233,
 	}
 	if bytes.Compare(out, correct) != 0 {
 		t.Error("wrong RNG output", out)
@@ -63,7 +64,7 @@ func TestAccumulator(t *testing.T) {
 
 	out = acc.RandomData(100)
 	correct = []byte{
-		251, 221, 252, 169, 162, 63, 79, 229, 91, 28, 159, 133, 19, 50, 239, 37, 141, 37, 178, 235, 86, 72, 60, 252, 125, 234, 248, 204, 81, 173, 118, 128, 246, 227, 174, 226, 86, 20, 227, 188, 185, 108, 57, 95, 162, 84, 250, 190, 56, 202, 122, 171, 237, 173, 61, 116, 252, 3, 128, 208, 56, 39, 73, 60, 18, 47, 37, 96, 107, 108, 67, 176, 254, 191, 235, 78, 170, 185, 202, 184, 26, 182, 9, 140, 0, 31, 34, 49, 195, 139, 20, 142, 178, 240, 187, 97, 184, 182, 170, 121,
+		221, 255, 180, 219, 219, 222, 160, 145, 14, 70, 255, 49, 96, 201, 70, 165, 208, 134, 174, 173, 101, 16, 91, 196, 248, 150, 4, 182, 245, 137, 227, 172, 215, 246, 71, 171, 242, 24, 7, 235, 216, 57, 3, 240, 201, 225, 39, 226, 124, 20, 1, 183, 109, 235, 39, 220, 101, 15, 207, 58, 60, 35, 139, 155, 254, 108, 65, 244, 20, 16, 53, 136, 72, 200, 229, 113, 142, 76, 63, 10, 139, 240, 5, 249, 148, 197, 195, 159, 62, 100, 181, 97, 70, 62, 111, 89, 88, 130, 113, 92,
 	}
 	if bytes.Compare(out, correct) != 0 {
 		t.Error("wrong RNG output", out)
@@ -100,6 +101,76 @@ func TestClose(t *testing.T) {
 	}
 }
 
+func TestReadAfterClose(t *testing.T) {
+	acc, err := NewRNG("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	acc.Close()
+
+	buf := make([]byte, 8)
+	n, err := acc.Read(buf)
+	if err != ErrClosed || n != 0 {
+		t.Error("Read after Close did not fail:", n, err)
+	}
+	n, err = acc.ReadContext(context.Background(), buf)
+	if err != ErrClosed || n != 0 {
+		t.Error("ReadContext after Close did not fail:", n, err)
+	}
+	if err := acc.WaitSeeded(context.Background()); err != ErrClosed {
+		t.Error("WaitSeeded after Close did not fail:", err)
+	}
+	if err := acc.Close(); err != ErrClosed {
+		t.Error("second Close did not fail:", err)
+	}
+}
+
+func TestReadContext(t *testing.T) {
+	acc, err := NewAccumulatorWithOptions(WithUnseededPolicy(UnseededBlock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer acc.Close()
+
+	buf := make([]byte, 8)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	n, err := acc.ReadContext(ctx, buf)
+	if err != context.Canceled || n != 0 {
+		t.Error("ReadContext with cancelled context did not fail:", n, err)
+	}
+
+	acc.addRandomEvent(0, 0, make([]byte, 32))
+	n, err = acc.ReadContext(context.Background(), buf)
+	if err != nil || n != len(buf) || isZero(buf) {
+		t.Error("ReadContext failed:", n, err)
+	}
+}
+
+func TestFatalHealthFailures(t *testing.T) {
+	acc, err := NewAccumulatorWithOptions(
+		WithFatalHealthFailures(),
+		WithSinkBufferSize(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer acc.Close()
+
+	sink := acc.NewEntropyDataSink(WithHealthConfig(HealthConfig{
+		RepetitionCutoff: 2,
+	}))
+	sink <- []byte{1}
+	sink <- []byte{1}
+	sink <- []byte{2} // make sure the second event has been processed
+
+	buf := make([]byte, 8)
+	_, err = acc.Read(buf)
+	herr, ok := err.(*HealthError)
+	if !ok || herr.Test != "repetition count" {
+		t.Error("health test failure not reported:", err)
+	}
+}
+
 func TestReseedingDuringClose(t *testing.T) {
 	tempDir, err := ioutil.TempDir("", "")
 	if err != nil {
@@ -123,6 +194,93 @@ func TestReseedingDuringClose(t *testing.T) {
 	acc.Close()
 }
 
+func TestUnseededError(t *testing.T) {
+	acc, err := NewAccumulatorWithOptions(WithUnseededPolicy(UnseededError))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer acc.Close()
+
+	if acc.IsSeeded() {
+		t.Error("new Accumulator is seeded")
+	}
+	buf := make([]byte, 16)
+	n, err := acc.Read(buf)
+	if err != ErrNotSeeded || n != 0 {
+		t.Error("unseeded Read did not fail:", n, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := acc.WaitSeeded(ctx); err != context.DeadlineExceeded {
+		t.Error("wrong error from WaitSeeded:", err)
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- acc.WaitSeeded(context.Background())
+	}()
+	acc.addRandomEvent(0, 0, make([]byte, 32))
+	if err := <-done; err != nil {
+		t.Error("WaitSeeded failed:", err)
+	}
+	if !acc.IsSeeded() || acc.reseedCount != 1 {
+		t.Error("Accumulator not seeded from pool 0")
+	}
+	n, err = acc.Read(buf)
+	if err != nil || n != len(buf) {
+		t.Error("seeded Read failed:", n, err)
+	}
+}
+
+func TestUnseededBlock(t *testing.T) {
+	acc, err := NewAccumulatorWithOptions(WithUnseededPolicy(UnseededBlock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer acc.Close()
+
+	done := make(chan bool)
+	go func() {
+		acc.Read(make([]byte, 16))
+		done <- true
+	}()
+	select {
+	case <-done:
+		t.Fatal("Read did not block")
+	case <-time.After(10 * time.Millisecond):
+	}
+	acc.addRandomEvent(0, 0, make([]byte, 32))
+	<-done
+}
+
+func TestSeededFromFile(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+	seedFileName := filepath.Join(tempDir, "seed")
+
+	acc, err := NewRNG(seedFileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if acc.IsSeeded() {
+		t.Error("seeded without seed file or entropy")
+	}
+	acc.Close()
+
+	acc, err = NewRNG(seedFileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !acc.IsSeeded() {
+		t.Error("not seeded from the seed file")
+	}
+	acc.Close()
+}
+
 func accumulatorRead(b *testing.B, n int) {
 	acc, _ := NewRNG("")
 	buffer := make([]byte, n)
@@ -0,0 +1,225 @@
+// autosave.go - automatic updates of the seed file
+// Copyright (C) 2013  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package fortuna
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrNoSeedStore is returned by SaveSeed() if the Accumulator was
+// created without a seed file or seed store.
+var ErrNoSeedStore = errors.New("no seed store configured")
+
+// maxAutoSaveBackoff limits how far the autosave goroutine backs off
+// while the Accumulator is idle, as a multiple of the autosave
+// interval.
+const maxAutoSaveBackoff = 64
+
+// WithAutoSaveTriggers sets how much activity causes the seed file to
+// be updated before the autosave interval (see WithAutoSaveInterval())
+// has passed: the seed file is written after the given number of
+// reseeds of the generator from the entropy pools, or after the
+// given number of bytes of random output.  Both values must be
+// positive; the defaults are 1024 reseeds and 64MiB.
+func WithAutoSaveTriggers(reseeds int, bytes int64) Option {
+	return func(cfg *config) error {
+		if reseeds < 1 {
+			return invalidOption("autosave reseed count must be positive, not %d", reseeds)
+		}
+		if bytes < 1 {
+			return invalidOption("autosave byte count must be positive, not %d", bytes)
+		}
+		cfg.autoSaveReseeds = reseeds
+		cfg.autoSaveBytes = bytes
+		return nil
+	}
+}
+
+// saveTimer is the timer used by the autosave goroutine.  The
+// default implementation uses time.Timer; tests replace it to control
+// the goroutine without waiting.
+type saveTimer interface {
+	C() <-chan time.Time
+
+	// Reset restarts the timer with delay d.  An expiry which has
+	// not been received yet is discarded.
+	Reset(d time.Duration)
+
+	Stop()
+}
+
+type realSaveTimer struct {
+	timer *time.Timer
+}
+
+func newRealSaveTimer(d time.Duration) saveTimer {
+	return realSaveTimer{timer: time.NewTimer(d)}
+}
+
+func (t realSaveTimer) C() <-chan time.Time {
+	return t.timer.C
+}
+
+func (t realSaveTimer) Reset(d time.Duration) {
+	if !t.timer.Stop() {
+		select {
+		case <-t.timer.C:
+		default:
+		}
+	}
+	t.timer.Reset(d)
+}
+
+func (t realSaveTimer) Stop() {
+	t.timer.Stop()
+}
+
+// wakeAutoSave asks the autosave goroutine to check whether the seed
+// file needs to be written.  The call never blocks.
+func (acc *Accumulator) wakeAutoSave() {
+	select {
+	case acc.saveWake <- struct{}{}:
+	default:
+	}
+}
+
+// noteReseed records a reseed of the generator from the entropy
+// pools.  The caller must hold acc.genMutex.
+func (acc *Accumulator) noteReseed() {
+	acc.outputBytes = 0
+	acc.unsavedReseeds++
+	if !acc.poolReseeded || acc.unsavedReseeds >= acc.cfg.autoSaveReseeds ||
+		acc.saveIdle {
+		acc.saveIdle = false
+		acc.wakeAutoSave()
+	}
+	acc.poolReseeded = true
+}
+
+// noteOutput records that n bytes of random output were generated.
+// The caller must hold acc.genMutex.
+func (acc *Accumulator) noteOutput(n uint) {
+	acc.outputBytes += int64(n)
+	acc.totalBytes += int64(n)
+	acc.unsavedBytes += int64(n)
+	if acc.unsavedBytes >= acc.cfg.autoSaveBytes || acc.saveIdle {
+		acc.saveIdle = false
+		acc.wakeAutoSave()
+	}
+}
+
+// autoSave runs in a separate goroutine and writes the seed file
+// when needed, until a value is received from quit.  While the
+// Accumulator is idle, the time between checks is doubled after
+// every check, up to maxAutoSaveBackoff times the autosave interval.
+// The first activity after an idle period wakes the goroutine, and
+// the time between checks is reset to the autosave interval.
+func (acc *Accumulator) autoSave(quit <-chan bool) {
+	interval := acc.cfg.autoSaveInterval
+	delay := interval
+	timer := acc.cfg.newSaveTimer(delay)
+	defer timer.Stop()
+	for {
+		select {
+		case <-quit:
+			return
+		case <-acc.saveWake:
+			acc.maybeSave(false)
+			if delay > interval {
+				delay = interval
+				timer.Reset(delay)
+			}
+		case <-timer.C():
+			if acc.maybeSave(true) {
+				delay = interval
+			} else if delay < maxAutoSaveBackoff*interval {
+				delay *= 2
+			}
+			timer.Reset(delay)
+		}
+	}
+}
+
+// maybeSave writes the seed file if this is required by the autosave
+// rules.  The argument timeout indicates whether the autosave timer
+// has expired.  The return value indicates whether there was any
+// activity since the seed file was last written.
+func (acc *Accumulator) maybeSave(timeout bool) bool {
+	acc.genMutex.Lock()
+	if acc.closed {
+		acc.genMutex.Unlock()
+		return false
+	}
+	now := acc.cfg.clock()
+	dirty := acc.unsavedReseeds > 0 || acc.unsavedBytes > 0
+	need := acc.poolReseeded && !acc.poolSeedSaved ||
+		acc.unsavedReseeds >= acc.cfg.autoSaveReseeds ||
+		acc.unsavedBytes >= acc.cfg.autoSaveBytes ||
+		timeout && dirty && now.Sub(acc.lastSaved) >= acc.cfg.autoSaveInterval
+	if timeout && !dirty {
+		// The autosave goroutine backs off; the next activity
+		// needs to wake it.
+		acc.saveIdle = true
+	}
+	acc.genMutex.Unlock()
+
+	if need {
+		err := acc.writeSeedFile()
+		acc.flushEvents()
+		if err != nil {
+			acc.seedLog.Error("cannot update the seed file",
+				logKeyError, err)
+		}
+		acc.genMutex.Lock()
+		acc.autoSaveErr = err
+		if err != nil {
+			acc.autoSaveFails++
+		}
+		acc.genMutex.Unlock()
+	}
+	return dirty
+}
+
+// SaveSeed writes new seed data to the seed file or seed store
+// immediately.  Normally this is not required, since the seed file
+// is updated automatically.  If the Accumulator has no seed file,
+// ErrNoSeedStore is returned; after Close() has been called,
+// ErrClosed is returned.
+func (acc *Accumulator) SaveSeed() error {
+	if acc.cfg.seedStore == nil && acc.cfg.seedFileName == "" {
+		return ErrNoSeedStore
+	}
+	acc.genMutex.Lock()
+	closed := acc.closed
+	acc.genMutex.Unlock()
+	if closed {
+		return ErrClosed
+	}
+	err := acc.writeSeedFile()
+	acc.flushEvents()
+	return err
+}
+
+// LastSaved returns the time when the seed file was last written
+// successfully.  If the Accumulator has no seed file, the zero time
+// is returned.
+func (acc *Accumulator) LastSaved() time.Time {
+	acc.genMutex.Lock()
+	defer acc.genMutex.Unlock()
+	return acc.lastSaved
+}
@@ -0,0 +1,255 @@
+// autosave_test.go - unit tests for autosave.go
+// Copyright (C) 2013  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package fortuna
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// storedCounter returns the write counter recorded by store.
+func storedCounter(store *MemorySeedStore) uint64 {
+	mark, _ := store.LoadMark()
+	return mark.Counter
+}
+
+// waitForSave waits until the store holds a seed with a write counter
+// of at least counter.
+func waitForSave(t *testing.T, store *MemorySeedStore, counter uint64) {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		c := storedCounter(store)
+		if c >= counter {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("seed with counter %d not saved", counter)
+}
+
+func TestAutoSaveFirstReseed(t *testing.T) {
+	store := NewMemorySeedStore()
+	acc, err := NewAccumulatorWithOptions(WithSeedStore(store))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer acc.Close()
+	if acc.LastSaved().IsZero() {
+		t.Error("initial save not recorded")
+	}
+
+	acc.addRandomEvent(0, 0, make([]byte, minPoolSize))
+	acc.RandomData(1)
+	waitForSave(t, store, 2)
+
+	// later reseeds don't cause a save by themselves
+	acc.nextReseed = time.Time{}
+	acc.addRandomEvent(0, 0, make([]byte, minPoolSize))
+	acc.RandomData(1)
+	time.Sleep(50 * time.Millisecond)
+	if c := storedCounter(store); c != 2 {
+		t.Errorf("unexpected save, counter %d", c)
+	}
+}
+
+func TestAutoSaveTriggers(t *testing.T) {
+	store := NewMemorySeedStore()
+	acc, err := NewAccumulatorWithOptions(WithSeedStore(store),
+		WithAutoSaveTriggers(2, 1000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer acc.Close()
+
+	acc.RandomData(999)
+	time.Sleep(50 * time.Millisecond)
+	if c := storedCounter(store); c != 1 {
+		t.Errorf("unexpected save, counter %d", c)
+	}
+	acc.RandomData(1)
+	waitForSave(t, store, 2)
+
+	for i := 0; i < 3; i++ {
+		acc.genMutex.Lock()
+		acc.reseed([]byte{byte(i)})
+		acc.genMutex.Unlock()
+	}
+	waitForSave(t, store, 3)
+
+	_, err = NewAccumulatorWithOptions(WithAutoSaveTriggers(0, 1))
+	if !errors.Is(err, ErrInvalidOption) {
+		t.Errorf("invalid trigger not detected: %v", err)
+	}
+}
+
+func TestAutoSaveIdle(t *testing.T) {
+	now := time.Unix(1000000000, 0)
+	clock := func() time.Time { return now }
+	store := NewMemorySeedStore()
+	acc, err := NewAccumulatorWithOptions(WithSeedStore(store), WithClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer acc.Close()
+
+	// an idle Accumulator is never saved
+	now = now.Add(time.Hour)
+	if acc.maybeSave(true) {
+		t.Error("idle Accumulator reported as active")
+	}
+	if c := storedCounter(store); c != 1 {
+		t.Errorf("idle Accumulator saved, counter %d", c)
+	}
+
+	// activity is saved once the interval has passed
+	acc.RandomData(1)
+	now = now.Add(time.Second)
+	if !acc.maybeSave(true) {
+		t.Error("activity not reported")
+	}
+	if c := storedCounter(store); c != 2 {
+		t.Errorf("activity not saved, counter %d", c)
+	}
+	if !acc.LastSaved().Equal(now) {
+		t.Errorf("wrong save time %s", acc.LastSaved())
+	}
+
+	acc.RandomData(1)
+	now = now.Add(time.Second)
+	acc.maybeSave(true)
+	if c := storedCounter(store); c != 2 {
+		t.Errorf("saved before the interval passed, counter %d", c)
+	}
+}
+
+// fakeSaveTimer is a saveTimer which only expires when the test
+// fires it.  Every call to Reset() is reported on the resets channel.
+type fakeSaveTimer struct {
+	c      chan time.Time
+	resets chan time.Duration
+}
+
+func (t *fakeSaveTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeSaveTimer) Reset(d time.Duration) {
+	t.resets <- d
+}
+
+func (t *fakeSaveTimer) Stop() {}
+
+func TestAutoSaveAfterIdle(t *testing.T) {
+	const interval = time.Minute
+	var mutex sync.Mutex
+	now := time.Unix(1000000000, 0)
+	clock := func() time.Time {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return now
+	}
+	advance := func(d time.Duration) time.Time {
+		mutex.Lock()
+		defer mutex.Unlock()
+		now = now.Add(d)
+		return now
+	}
+	timer := &fakeSaveTimer{
+		c:      make(chan time.Time),
+		resets: make(chan time.Duration, 16),
+	}
+
+	store := NewMemorySeedStore()
+	acc, err := NewAccumulatorWithOptions(WithSeedStore(store),
+		WithClock(clock), WithAutoSaveInterval(interval),
+		func(cfg *config) error {
+			cfg.newSaveTimer = func(d time.Duration) saveTimer {
+				if d != interval {
+					t.Errorf("wrong initial delay %s", d)
+				}
+				return timer
+			}
+			return nil
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer acc.Close()
+
+	// While the Accumulator is idle, the autosave goroutine backs off.
+	delay := interval
+	for i := 0; i < 3; i++ {
+		timer.c <- advance(delay)
+		delay *= 2
+		if d := <-timer.resets; d != delay {
+			t.Fatalf("idle check %d: wrong delay %s", i, d)
+		}
+	}
+	if c := storedCounter(store); c != 1 {
+		t.Errorf("idle Accumulator saved, counter %d", c)
+	}
+
+	// The first activity wakes the goroutine and restores the
+	// autosave interval.
+	acc.RandomData(1)
+	if d := <-timer.resets; d != interval {
+		t.Fatalf("wrong delay %s after activity", d)
+	}
+	timer.c <- advance(interval)
+	if d := <-timer.resets; d != interval {
+		t.Fatalf("wrong delay %s after save", d)
+	}
+	if c := storedCounter(store); c != 2 {
+		t.Errorf("activity after idle period not saved, counter %d", c)
+	}
+	if !acc.LastSaved().Equal(clock()) {
+		t.Errorf("wrong save time %s", acc.LastSaved())
+	}
+}
+
+func TestSaveSeed(t *testing.T) {
+	acc, err := NewAccumulatorWithOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := acc.SaveSeed(); err != ErrNoSeedStore {
+		t.Errorf("wrong error %v", err)
+	}
+	if !acc.LastSaved().IsZero() {
+		t.Error("LastSaved() without a seed store")
+	}
+	acc.Close()
+
+	store := NewMemorySeedStore()
+	acc, err = NewAccumulatorWithOptions(WithSeedStore(store))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = acc.SaveSeed()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := storedCounter(store); c != 2 {
+		t.Errorf("seed not saved, counter %d", c)
+	}
+	acc.Close()
+	if err := acc.SaveSeed(); err != ErrClosed {
+		t.Errorf("wrong error after Close: %v", err)
+	}
+}
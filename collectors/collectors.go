@@ -0,0 +1,115 @@
+// collectors.go - entropy collectors for an Accumulator
+// Copyright (C) 2013  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package collectors provides entropy sources which feed the pools of
+// a fortuna.Accumulator.  The collectors are opt-in: they run only if
+// they are started explicitly, for example
+//
+//	rng, err := fortuna.NewRNG(seedFileName)
+//	...
+//	sys, err := collectors.NewSystem(rng)
+//	...
+//	defer sys.Close()
+//
+// The System collector samples counters maintained by the operating
+// system, and the Jitter collector measures CPU timing jitter.
+//
+// Every collector registers named entropy sources with the
+// Accumulator (see Accumulator.NewSource()), so that the amount of
+// data and credited entropy from each collector is reported by
+// Accumulator.Sources().  The entropy credits used by the collectors
+// are deliberately conservative.  A collector must be closed before
+// the Accumulator it feeds is closed.
+package collectors
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/seehuhn/fortuna"
+)
+
+// ErrNotSupported is returned by the constructors of collectors which
+// are not available on the current system.
+var ErrNotSupported = errors.New("collector not supported on this system")
+
+// config collects the settings of a collector.
+type config struct {
+	interval time.Duration
+	prefix   string
+}
+
+func defaultConfig(prefix string, interval time.Duration) *config {
+	return &config{
+		interval: interval,
+		prefix:   prefix,
+	}
+}
+
+// Option is the type of the optional arguments of the collector
+// constructors.
+type Option func(cfg *config) error
+
+func invalidOption(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", fortuna.ErrInvalidOption, fmt.Sprintf(format, args...))
+}
+
+// WithInterval sets the mean time between two samples.  The default
+// depends on the collector.  The actual
+// times are chosen at random between half and one and a half times
+// this value, so that samples are not taken in lock step with
+// periodic activity on the system.  The value must be positive.
+func WithInterval(d time.Duration) Option {
+	return func(cfg *config) error {
+		if d <= 0 {
+			return invalidOption("sample interval must be positive, not %s", d)
+		}
+		cfg.interval = d
+		return nil
+	}
+}
+
+// WithSourcePrefix sets the prefix of the names of the entropy
+// sources registered by a collector.  This allows to run several
+// instances of a collector for the same Accumulator.  The prefix must
+// not be empty.
+func WithSourcePrefix(prefix string) Option {
+	return func(cfg *config) error {
+		if prefix == "" {
+			return invalidOption("source prefix must not be empty")
+		}
+		cfg.prefix = prefix
+		return nil
+	}
+}
+
+func newConfig(prefix string, interval time.Duration, opts []Option) (*config, error) {
+	cfg := defaultConfig(prefix, interval)
+	for _, opt := range opts {
+		err := opt(cfg)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return cfg, nil
+}
+
+// jitter returns a random duration between d/2 and 3d/2.
+func jitter(d time.Duration) time.Duration {
+	return d/2 + time.Duration(rand.Int63n(int64(d)+1))
+}
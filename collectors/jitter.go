@@ -0,0 +1,402 @@
+// jitter.go - an entropy source based on CPU timing jitter
+// Copyright (C) 2013  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package collectors
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/seehuhn/fortuna"
+)
+
+const (
+	// jitterInterval is the default mean time between two events of
+	// the Jitter collector.
+	jitterInterval = time.Second
+
+	// jitterSamples is the number of timing measurements per event.
+	jitterSamples = 64
+
+	// jitterCalibration is the number of measurements used to assess
+	// the entropy of the timer at start-up.
+	jitterCalibration = 1024
+
+	// jitterCalMemLoops and jitterCalHashLoops are the fixed numbers
+	// of iterations of the memory access loop and the hash loop used
+	// during calibration.  These are the smallest values used in
+	// normal operation.
+	jitterCalMemLoops  = 64
+	jitterCalHashLoops = 1
+
+	// jitterMemSize is the size of the memory area used by the
+	// memory access loop.  The area is larger than typical L1 caches,
+	// so that the access times depend on the state of the caches.
+	jitterMemSize = 128 << 10
+
+	// jitterMemStride is the distance between two memory accesses.
+	// This is prime and larger than a cache line.
+	jitterMemStride = 4099
+
+	// jitterSafety is the factor by which the entropy credit is
+	// smaller than the assessed min-entropy of the measurements.
+	jitterSafety = 3
+
+	// jitterMinCredit is the minimal credit per measurement.  If the
+	// timer gives less entropy than this, the collector is not used.
+	jitterMinCredit = 1.0 / 16
+)
+
+// Jitter is a collector which measures the variation in the execution
+// time of a memory access loop and a hash loop, following the design
+// of the jitterentropy RNG of the Linux kernel.  The variation is
+// caused by caches, pipelines, frequency scaling and interrupts, and
+// does not depend on the kernel random number generator.  This makes
+// the collector useful for virtual machines and containers with
+// little interrupt activity.
+//
+// Every event submitted to the Accumulator consists of 64 time
+// differences, measured with the monotonic clock in nanoseconds.  A
+// measurement is "stuck", and is not credited, if its first or second
+// or third discrete derivative is zero.  The entropy credit of the
+// remaining measurements is calibrated when the collector starts, see
+// NewJitter().
+//
+// The measurements are checked by the continuous health tests from
+// NIST SP 800-90B, with cutoff values derived from the calibrated
+// credit.  The tests are run by the Accumulator on every measurement
+// (see fortuna.WithSampleSize()), so that failures are counted in
+// the Accumulator's Stats and are reported to its observers and
+// health failure handler, like for any other source.  The collector
+// runs the same tests on its own measurements: if a test fails, the
+// event containing the failure is still submitted, the collector
+// stops, and Err() returns the failure.
+type Jitter struct {
+	sink   chan<- []byte
+	credit float64
+	health *fortuna.HealthTest
+	state  *jitterState
+
+	quit      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+
+	errMutex sync.Mutex
+	err      error
+}
+
+// NewJitter starts a Jitter collector for acc.  The collector
+// registers one entropy source, with the name "jitter/cpu" unless a
+// different prefix is set using WithSourcePrefix().  Events are
+// generated every second on average, see WithInterval().
+//
+// Before the source is registered, 1024 measurements are taken and
+// their min-entropy is estimated using the most common value estimate
+// from section 6.3.1 of NIST SP 800-90B.  For calibration, the loops
+// are run with a fixed number of iterations, since the varying
+// workload of normal operation spreads out the measured times without
+// adding entropy.  Every non-stuck measurement is credited with a
+// third of this estimate, but with at most one bit.  The credit for
+// an event is further limited by the Accumulator, see
+// fortuna.WithMaxEventCredit().  If the estimate is too small, for example because the clock
+// has a coarse resolution, an error wrapping ErrNotSupported is
+// returned.
+//
+// The collector must be stopped using the Close() method before acc
+// is closed.
+func NewJitter(acc *fortuna.Accumulator, opts ...Option) (*Jitter, error) {
+	cfg, err := newConfig("jitter/", jitterInterval, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	state := newJitterState()
+	credit := state.calibrate(jitterCalibration)
+	if credit < jitterMinCredit {
+		return nil, fmt.Errorf("%w: timer jitter too small (%.3f bits per sample)",
+			ErrNotSupported, credit)
+	}
+
+	name := cfg.prefix + "cpu"
+	healthCfg := jitterHealthConfig(credit)
+	sink, err := acc.NewSource(name,
+		fortuna.WithEntropyEstimator(func(data []byte) float64 {
+			return jitterCredit(data, credit)
+		}),
+		fortuna.WithHealthConfig(healthCfg),
+		fortuna.WithSampleSize(8),
+		fortuna.WithSourceMetadata(map[string]string{
+			"collector":         "jitter",
+			"credit_per_sample": strconv.FormatFloat(credit, 'g', 4, 64),
+		}))
+	if err != nil {
+		return nil, err
+	}
+
+	health := fortuna.NewHealthTest(healthCfg)
+	j := &Jitter{
+		sink:   sink,
+		credit: credit,
+		health: health,
+		state:  state,
+		quit:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go j.run(name, cfg.interval)
+	return j, nil
+}
+
+// Credit returns the entropy credit, in bits, for every measurement
+// which is not stuck.
+func (j *Jitter) Credit() float64 {
+	return j.credit
+}
+
+// Err returns the error which stopped the collector, or nil if the
+// collector is still running or was stopped by Close().  Health test
+// failures are reported as *fortuna.HealthError.
+func (j *Jitter) Err() error {
+	j.errMutex.Lock()
+	defer j.errMutex.Unlock()
+	return j.err
+}
+
+func (j *Jitter) run(name string, interval time.Duration) {
+	defer close(j.done)
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+		case <-j.quit:
+			return
+		}
+
+		data := make([]byte, 8*jitterSamples)
+		var herr *fortuna.HealthError
+		for i := 0; i < jitterSamples; i++ {
+			delta := j.state.measure()
+			binary.BigEndian.PutUint64(data[8*i:], delta)
+			if herr == nil {
+				if err := j.health.Sample(data[8*i : 8*i+8]); err != nil {
+					herr = err.(*fortuna.HealthError)
+				}
+			}
+		}
+
+		// The event is submitted even if a test failed, so that the
+		// Accumulator detects and reports the same failure.
+		select {
+		case j.sink <- data:
+		case <-j.quit:
+			return
+		}
+		if herr != nil {
+			herr.SourceName = name
+			j.errMutex.Lock()
+			j.err = herr
+			j.errMutex.Unlock()
+			return
+		}
+		timer.Reset(jitter(interval))
+	}
+}
+
+// Close stops the collector and closes its entropy source.  Close can
+// be called more than once.  The returned error is always nil; use
+// Err() to check for health test failures.
+func (j *Jitter) Close() error {
+	j.closeOnce.Do(func() {
+		close(j.quit)
+		<-j.done
+		close(j.sink)
+	})
+	return nil
+}
+
+// jitterState holds the memory and the hash state used for the
+// timing measurements.
+type jitterState struct {
+	mem   []byte
+	pos   int
+	hash  [sha256.Size]byte
+	delta uint64
+}
+
+func newJitterState() *jitterState {
+	return &jitterState{
+		mem: make([]byte, jitterMemSize),
+	}
+}
+
+// measure runs the memory access loop and the hash loop once and
+// returns the elapsed time in nanoseconds.  The number of iterations
+// of both loops depends on the previous measurement, as in
+// jitterentropy.
+func (s *jitterState) measure() uint64 {
+	memLoops := jitterCalMemLoops + int(s.delta&0x3f)
+	hashLoops := jitterCalHashLoops + int(s.delta>>6&0x3)
+	return s.run(memLoops, hashLoops)
+}
+
+// run executes the given numbers of iterations of the memory access
+// loop and the hash loop and returns the elapsed time in nanoseconds.
+func (s *jitterState) run(memLoops, hashLoops int) uint64 {
+	start := time.Now()
+	mem := s.mem
+	pos := s.pos
+	for i := 0; i < memLoops; i++ {
+		mem[pos]++
+		pos = (pos + jitterMemStride) % len(mem)
+	}
+	s.pos = pos
+	var buf [sha256.Size + 8]byte
+	for i := 0; i < hashLoops; i++ {
+		copy(buf[:], s.hash[:])
+		binary.BigEndian.PutUint64(buf[sha256.Size:], s.delta)
+		s.hash = sha256.Sum256(buf[:])
+	}
+	s.delta = uint64(time.Since(start))
+	return s.delta
+}
+
+// calibrate takes n measurements with a fixed workload and returns
+// the entropy credit per non-stuck measurement: a third of the most
+// common value estimate of the min-entropy of the raw time
+// differences, but at most one bit.
+func (s *jitterState) calibrate(n int) float64 {
+	for i := 0; i < 64; i++ {
+		// warm up caches and branch predictors
+		s.run(jitterCalMemLoops, jitterCalHashLoops)
+	}
+	deltas := make([]uint64, n)
+	for i := range deltas {
+		deltas[i] = s.run(jitterCalMemLoops, jitterCalHashLoops)
+	}
+	h := mostCommonValueEntropy(deltas)
+
+	// The stuck test rejects measurements without variation.  If
+	// most measurements are stuck, the timer is unusable.
+	if stuck := countStuck(deltas); 2*stuck > n {
+		return 0
+	}
+
+	credit := h / jitterSafety
+	if credit > 1 {
+		credit = 1
+	}
+	return credit
+}
+
+// mostCommonValueEntropy estimates the min-entropy per sample, in
+// bits, using the most common value estimate from section 6.3.1 of
+// NIST SP 800-90B.
+func mostCommonValueEntropy(samples []uint64) float64 {
+	n := len(samples)
+	sorted := append([]uint64{}, samples...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	maxCount := 0
+	for i := 0; i < n; {
+		j := i + 1
+		for j < n && sorted[j] == sorted[i] {
+			j++
+		}
+		if j-i > maxCount {
+			maxCount = j - i
+		}
+		i = j
+	}
+
+	p := float64(maxCount) / float64(n)
+	pu := p + 2.576*math.Sqrt(p*(1-p)/float64(n-1))
+	if pu > 1 {
+		pu = 1
+	}
+	return -math.Log2(pu)
+}
+
+// countStuck returns the number of stuck measurements in deltas.  The
+// first two measurements are always counted as stuck, since their
+// derivatives are not known.
+func countStuck(deltas []uint64) int {
+	stuck := 0
+	for i := range deltas {
+		if i < 2 {
+			stuck++
+			continue
+		}
+		d1 := int64(deltas[i] - deltas[i-1])
+		d2 := d1 - int64(deltas[i-1]-deltas[i-2])
+		if deltas[i] == 0 || d1 == 0 || d2 == 0 {
+			stuck++
+		}
+	}
+	return stuck
+}
+
+// jitterCredit returns the entropy credit for an event, given the
+// credit per non-stuck measurement.
+func jitterCredit(data []byte, perSample float64) float64 {
+	deltas := make([]uint64, len(data)/8)
+	for i := range deltas {
+		deltas[i] = binary.BigEndian.Uint64(data[8*i:])
+	}
+	return float64(len(deltas)-countStuck(deltas)) * perSample
+}
+
+// jitterHealthConfig returns the cutoff values for the health tests
+// of a source with the given min-entropy per sample, for a false
+// positive probability of 2^-20, as described in section 4.4 of NIST
+// SP 800-90B.
+func jitterHealthConfig(h float64) fortuna.HealthConfig {
+	const window = 512
+	adaptiveCutoff := 1 + binomialCutoff(window, math.Exp2(-h), math.Exp2(-20))
+	if adaptiveCutoff > window {
+		adaptiveCutoff = window
+	}
+	return fortuna.HealthConfig{
+		RepetitionCutoff: 1 + int(math.Ceil(20/h)),
+		AdaptiveWindow:   window,
+		AdaptiveCutoff:   adaptiveCutoff,
+	}
+}
+
+// binomialCutoff returns the smallest c such that a binomial random
+// variable with parameters n and p exceeds c with probability at most
+// alpha.  This is CRITBINOM(n, p, 1-alpha) in the notation of SP
+// 800-90B.
+func binomialCutoff(n int, p, alpha float64) int {
+	// Sum the upper tail, starting from k = n.
+	tail := 0.0
+	for k := n; k >= 0; k-- {
+		lg1, _ := math.Lgamma(float64(n + 1))
+		lg2, _ := math.Lgamma(float64(k + 1))
+		lg3, _ := math.Lgamma(float64(n - k + 1))
+		logPk := lg1 - lg2 - lg3 + float64(k)*math.Log(p) + float64(n-k)*math.Log1p(-p)
+		tail += math.Exp(logPk)
+		if tail > alpha {
+			return k
+		}
+	}
+	return 0
+}
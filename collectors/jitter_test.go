@@ -0,0 +1,161 @@
+// jitter_test.go - unit tests for jitter.go
+// Copyright (C) 2013  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package collectors
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/seehuhn/fortuna"
+)
+
+func TestJitter(t *testing.T) {
+	acc, err := fortuna.NewAccumulatorWithOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer acc.Close()
+
+	j, err := NewJitter(acc, WithInterval(time.Millisecond))
+	if errors.Is(err, ErrNotSupported) {
+		t.Skip(err)
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	if c := j.Credit(); !(c >= jitterMinCredit && c <= 1) {
+		t.Errorf("wrong credit %g", c)
+	}
+
+	var src fortuna.SourceInfo
+	for i := 0; ; i++ {
+		src = acc.Sources()[0]
+		if src.Events >= 2 {
+			break
+		}
+		if i > 1000 {
+			t.Fatal("no data from the collector")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if src.Name != "jitter/cpu" || src.Metadata["collector"] != "jitter" {
+		t.Errorf("wrong source %v", src)
+	}
+	if src.Credit <= 0 || src.Credit > float64(src.Events)*jitterSamples*j.Credit() {
+		t.Errorf("wrong credit %g for %d events", src.Credit, src.Events)
+	}
+
+	j.Close()
+	j.Close()
+	if err := j.Err(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestJitterEventLimit(t *testing.T) {
+	acc, err := fortuna.NewAccumulatorWithOptions(fortuna.WithMaxEventCredit(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer acc.Close()
+
+	j, err := NewJitter(acc, WithInterval(time.Millisecond))
+	if errors.Is(err, ErrNotSupported) {
+		t.Skip(err)
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+
+	var src fortuna.SourceInfo
+	for i := 0; ; i++ {
+		src = acc.Sources()[0]
+		if src.Events >= 2 {
+			break
+		}
+		if i > 1000 {
+			t.Fatal("no data from the collector")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if src.Credit > 2*float64(src.Events) {
+		t.Errorf("credit %g for %d events exceeds the event limit",
+			src.Credit, src.Events)
+	}
+}
+
+func TestJitterCredit(t *testing.T) {
+	deltas := []uint64{100, 105, 103, 103, 110, 117, 0, 50}
+	// stuck: the first two, 103 (no change), 117 (constant
+	// change) and 0
+	if n := countStuck(deltas); n != 5 {
+		t.Errorf("wrong number of stuck measurements: %d", n)
+	}
+
+	data := make([]byte, 8*len(deltas))
+	for i, d := range deltas {
+		binary.BigEndian.PutUint64(data[8*i:], d)
+	}
+	if c := jitterCredit(data, 0.5); c != 1.5 {
+		t.Errorf("wrong credit %g", c)
+	}
+}
+
+func TestMostCommonValue(t *testing.T) {
+	samples := make([]uint64, 1000)
+	if h := mostCommonValueEntropy(samples); h != 0 {
+		t.Errorf("constant samples: wrong entropy %g", h)
+	}
+	for i := range samples {
+		samples[i] = uint64(i % 2)
+	}
+	// p = 0.5, upper bound 0.5 + 2.576*sqrt(0.25/999)
+	expected := -math.Log2(0.5 + 2.576*math.Sqrt(0.25/999))
+	if h := mostCommonValueEntropy(samples); math.Abs(h-expected) > 1e-12 {
+		t.Errorf("binary samples: wrong entropy %g != %g", h, expected)
+	}
+}
+
+func TestJitterHealthConfig(t *testing.T) {
+	// cutoff values from table 2 of NIST SP 800-90B, for W = 512
+	cases := []struct {
+		h                float64
+		repeat, adaptive int
+	}{
+		{0.5, 41, 410},
+		{1, 21, 311},
+		{2, 11, 177},
+	}
+	for _, c := range cases {
+		cfg := jitterHealthConfig(c.h)
+		if cfg.RepetitionCutoff != c.repeat || cfg.AdaptiveWindow != 512 ||
+			cfg.AdaptiveCutoff != c.adaptive {
+			t.Errorf("H=%g: wrong config %+v", c.h, cfg)
+		}
+	}
+
+	health := fortuna.NewHealthTest(jitterHealthConfig(1))
+	var err error
+	for i := 0; i < 21 && err == nil; i++ {
+		err = health.Sample([]byte{0, 0, 0, 0, 0, 0, 0, 1})
+	}
+	if !errors.Is(err, fortuna.ErrHealthTest) {
+		t.Errorf("constant measurements not detected: %v", err)
+	}
+}
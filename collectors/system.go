@@ -0,0 +1,169 @@
+// system.go - sample the state of the operating system
+// Copyright (C) 2013  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package collectors
+
+import (
+	"crypto/sha256"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"github.com/seehuhn/fortuna"
+)
+
+// systemInterval is the default mean time between two samples of the
+// System collector.
+const systemInterval = 10 * time.Second
+
+// input is one quantity sampled by the System collector.
+type input struct {
+	// name is appended to the source prefix to form the name of the
+	// entropy source.
+	name string
+
+	// credit is the entropy credit, in bits, for every sample which
+	// differs from the previous one.
+	credit float64
+
+	// path is the file the data is read from, or empty if read is
+	// set.
+	path string
+	read func() ([]byte, error)
+}
+
+func (in *input) sample() ([]byte, error) {
+	if in.read != nil {
+		return in.read()
+	}
+	return ioutil.ReadFile(in.path)
+}
+
+// System is a collector which regularly samples counters maintained
+// by the operating system: interrupt counts, disk and network
+// statistics, CPU times, and the resource usage and scheduler
+// statistics of the current process.  The low-order digits of these
+// counters are difficult to predict for an attacker, but since an
+// attacker on the same host can observe most of them, every sample is
+// credited with at most a few bits of entropy.  Samples which are
+// identical to the previous sample from the same input are discarded.
+//
+// The System collector is only available on Linux.
+type System struct {
+	inputs []input
+	sinks  []chan<- []byte
+
+	quit      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewSystem starts a System collector for acc.  One entropy source is
+// registered for every input which can be read on the current system;
+// the names of the sources start with "system/", unless a different
+// prefix is set using WithSourcePrefix().  Samples are taken every 10
+// seconds on average, see WithInterval().  If no input is available,
+// ErrNotSupported is returned.
+//
+// The collector must be stopped using the Close() method before acc
+// is closed.
+func NewSystem(acc *fortuna.Accumulator, opts ...Option) (*System, error) {
+	cfg, err := newConfig("system/", systemInterval, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	sys := &System{
+		quit: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	for _, in := range systemInputs {
+		if _, err := in.sample(); err != nil {
+			continue
+		}
+		metadata := map[string]string{"collector": "system"}
+		if in.path != "" {
+			metadata["path"] = in.path
+		}
+		sink, err := acc.NewSource(cfg.prefix+in.name,
+			fortuna.WithEntropyCredit(in.credit),
+			fortuna.WithSourceMetadata(metadata))
+		if err != nil {
+			sys.closeSinks()
+			return nil, err
+		}
+		sys.inputs = append(sys.inputs, in)
+		sys.sinks = append(sys.sinks, sink)
+	}
+	if len(sys.inputs) == 0 {
+		return nil, ErrNotSupported
+	}
+
+	go sys.run(cfg.interval)
+	return sys, nil
+}
+
+func (sys *System) run(interval time.Duration) {
+	defer close(sys.done)
+
+	last := make([][sha256.Size]byte, len(sys.inputs))
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+		case <-sys.quit:
+			return
+		}
+
+		for i := range sys.inputs {
+			data, err := sys.inputs[i].sample()
+			if err != nil {
+				continue
+			}
+			sum := sha256.Sum256(data)
+			if sum == last[i] {
+				continue
+			}
+			last[i] = sum
+
+			select {
+			case sys.sinks[i] <- data:
+			case <-sys.quit:
+				return
+			}
+		}
+		timer.Reset(jitter(interval))
+	}
+}
+
+// Close stops the collector and closes its entropy sources.  Close
+// can be called more than once.  The returned error is always nil.
+func (sys *System) Close() error {
+	sys.closeOnce.Do(func() {
+		close(sys.quit)
+		<-sys.done
+		sys.closeSinks()
+	})
+	return nil
+}
+
+func (sys *System) closeSinks() {
+	for _, sink := range sys.sinks {
+		close(sink)
+	}
+	sys.sinks = nil
+}
@@ -0,0 +1,105 @@
+// system_test.go - unit tests for system.go
+// Copyright (C) 2013  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package collectors
+
+import (
+	"errors"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/seehuhn/fortuna"
+)
+
+func TestSystem(t *testing.T) {
+	acc, err := fortuna.NewAccumulatorWithOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer acc.Close()
+
+	sys, err := NewSystem(acc, WithInterval(time.Millisecond))
+	if runtime.GOOS != "linux" {
+		if !errors.Is(err, ErrNotSupported) {
+			t.Errorf("wrong error %v", err)
+		}
+		return
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every input is sampled immediately.
+	for i := 0; ; i++ {
+		events := 0
+		for _, src := range acc.Sources() {
+			if src.Events > 0 {
+				events++
+			}
+		}
+		if events > 0 && events == len(sys.inputs) {
+			break
+		}
+		if i > 1000 {
+			t.Fatalf("no data from the collector: %v", acc.Sources())
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	_, err = NewSystem(acc)
+	if !errors.Is(err, fortuna.ErrDuplicateSource) {
+		t.Errorf("duplicate collector: wrong error %v", err)
+	}
+	sys2, err := NewSystem(acc, WithSourcePrefix("other/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, src := range acc.Sources() {
+		if !strings.HasPrefix(src.Name, "system/") &&
+			!strings.HasPrefix(src.Name, "other/") {
+			t.Errorf("unexpected source %q", src.Name)
+		}
+		if src.Metadata["collector"] != "system" {
+			t.Errorf("wrong metadata %v", src.Metadata)
+		}
+	}
+	sys2.Close()
+
+	sys.Close()
+	sys.Close()
+	for i := 0; len(acc.Sources()) > 0; i++ {
+		if i > 1000 {
+			t.Fatal("sources not closed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	stats := acc.Stats()
+	if stats.ClosedSourceCredit > 2*float64(stats.ClosedSourceEvents) {
+		t.Errorf("too much credit %g for %d events",
+			stats.ClosedSourceCredit, stats.ClosedSourceEvents)
+	}
+}
+
+func TestInvalidOptions(t *testing.T) {
+	for _, opt := range []Option{WithInterval(0), WithSourcePrefix("")} {
+		_, err := NewSystem(nil, opt)
+		if !errors.Is(err, fortuna.ErrInvalidOption) {
+			t.Errorf("wrong error %v", err)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+// +build linux
+
+package collectors
+
+import (
+	"bytes"
+	"encoding/binary"
+	"syscall"
+)
+
+// systemInputs lists the inputs of the System collector.  Interrupt
+// counts and the CPU times in /proc/stat change constantly and are
+// credited with 2 bits per sample; the other inputs often change only
+// in a few low-order digits and are credited with 1 bit.
+var systemInputs = []input{
+	{name: "interrupts", credit: 2, path: "/proc/interrupts"},
+	{name: "stat", credit: 2, path: "/proc/stat"},
+	{name: "diskstats", credit: 1, path: "/proc/diskstats"},
+	{name: "net/dev", credit: 1, path: "/proc/net/dev"},
+	{name: "schedstat", credit: 1, path: "/proc/self/schedstat"},
+	{name: "rusage", credit: 1, read: readRusage},
+}
+
+// readRusage returns the resource usage of the current process, as
+// reported by getrusage(2).
+func readRusage() ([]byte, error) {
+	var usage syscall.Rusage
+	err := syscall.Getrusage(syscall.RUSAGE_SELF, &usage)
+	if err != nil {
+		return nil, err
+	}
+	buf := &bytes.Buffer{}
+	binary.Write(buf, binary.LittleEndian, &usage)
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,9 @@
+// +build !linux
+
+package collectors
+
+// systemInputs is empty on this system.
+//
+// On Linux, systemInputs lists the files in /proc and the system
+// calls sampled by the System collector.
+var systemInputs []input
@@ -16,6 +16,12 @@
 
 package fortuna
 
+import (
+	"hash"
+
+	"golang.org/x/crypto/blake2b"
+)
+
 func bytesToInt64(bytes []byte) int64 {
 	var res int64
 	res = int64(bytes[0])
@@ -48,3 +54,26 @@ func wipe(data []byte) {
 		data[i] = 0
 	}
 }
+
+// wipeXOF overwrites the internal state of x with values which do not
+// depend on any data previously written to or read from x.  Resetting
+// the XOF clears the chaining values, and writing a full block of
+// zeros replaces the buffered input, which Reset leaves in place.
+// Reading a single byte after a second reset replaces the buffered
+// root hash and output block.
+func wipeXOF(x blake2b.XOF) {
+	x.Reset()
+	x.Write(make([]byte, blake2b.BlockSize))
+	x.Reset()
+	var buf [1]byte
+	x.Read(buf[:])
+}
+
+// wipeHash overwrites the internal state of the unkeyed hash h.
+// Resetting the hash clears the chaining values, and writing a full
+// block of zeros afterwards replaces the buffered input.
+func wipeHash(h hash.Hash) {
+	h.Reset()
+	h.Write(make([]byte, h.BlockSize()))
+	h.Reset()
+}
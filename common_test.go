@@ -19,6 +19,7 @@ package fortuna
 import (
 	"bytes"
 	"math"
+	"reflect"
 	"testing"
 )
 
@@ -78,3 +79,59 @@ func TestWipe(t *testing.T) {
 		t.Error("wipe failed")
 	}
 }
+
+// stateBytes collects the contents of all byte arrays found in the
+// (unexported) fields of v.
+func stateBytes(v reflect.Value) []byte {
+	var res []byte
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if !v.IsNil() {
+			res = stateBytes(v.Elem())
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			res = append(res, stateBytes(v.Field(i))...)
+		}
+	case reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			for i := 0; i < v.Len(); i++ {
+				res = append(res, byte(v.Index(i).Uint()))
+			}
+		} else {
+			for i := 0; i < v.Len(); i++ {
+				res = append(res, stateBytes(v.Index(i))...)
+			}
+		}
+	}
+	return res
+}
+
+func TestWipeXOF(t *testing.T) {
+	secret := make([]byte, keySize+64)
+	for i := range secret {
+		secret[i] = byte(0x80 + i)
+	}
+	xof := newXOF()
+	xof.Write(secret)
+	out := make([]byte, 200)
+	xof.Read(out)
+
+	state := stateBytes(reflect.ValueOf(xof))
+	if !bytes.Contains(state, secret[len(secret)-8:]) {
+		t.Fatal("secret not found in the XOF state before wiping")
+	}
+
+	wipeXOF(xof)
+	state = stateBytes(reflect.ValueOf(xof))
+	for i := 0; i+8 <= len(secret); i++ {
+		if bytes.Contains(state, secret[i:i+8]) {
+			t.Fatalf("input bytes %d..%d survived wipeXOF", i, i+7)
+		}
+	}
+	for i := 0; i+8 <= len(out); i++ {
+		if bytes.Contains(state, out[i:i+8]) {
+			t.Fatalf("output bytes %d..%d survived wipeXOF", i, i+7)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+// credit.go - entropy estimates for the entropy sources
+// Copyright (C) 2013  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package fortuna
+
+import (
+	"math"
+)
+
+// Every event added to pool 0 is credited with an amount of entropy,
+// measured in bits.  The generator is reseeded once the credited
+// entropy in pool 0 reaches the threshold set by WithMinPoolEntropy().
+// Unless a source declares a different value, the following
+// conservative defaults are used.
+const (
+	// defaultDataCredit is the credit for each byte of data submitted
+	// through NewSource() or NewEntropyDataSink().
+	defaultDataCredit = 1
+
+	// defaultTimeStampCredit is the credit for each time stamp
+	// submitted through NewTimeStampSource() or
+	// NewEntropyTimeStampSink().
+	defaultTimeStampCredit = 1
+)
+
+// WithEntropyCredit sets the amount of entropy, in bits, which is
+// credited for every event submitted by the source.  The value must
+// be finite and non-negative; a credit of 0 means that the data is
+// mixed into the pools but never triggers a reseed.  By default, data
+// sources are credited 1 bit per byte, and time stamp sources 1 bit
+// per time stamp.  The credit for an event is never larger than the
+// number of bits in the event (at most 256, since longer events are
+// condensed to 32 bytes), and is limited by WithMaxEventCredit().
+func WithEntropyCredit(bits float64) SinkOption {
+	return func(cfg *sinkConfig) {
+		cfg.estimate = func([]byte) float64 { return bits }
+		cfg.creditErr = checkCredit(bits)
+	}
+}
+
+// WithEntropyEstimator sets a function which estimates the entropy,
+// in bits, of every event submitted by the source.  For time stamp
+// sources, the function is called with the time difference to the
+// previous time stamp, in nanoseconds, encoded as an 8 byte
+// big-endian integer.  The function is called from the goroutine which
+// services the source.  Negative estimates are treated as zero, and
+// estimates larger than the number of bits in the event, or than the
+// limit set by WithMaxEventCredit(), are reduced accordingly.
+func WithEntropyEstimator(estimate func(data []byte) float64) SinkOption {
+	return func(cfg *sinkConfig) {
+		cfg.estimate = estimate
+		cfg.creditErr = nil
+	}
+}
+
+func checkCredit(bits float64) error {
+	if !(bits >= 0) || math.IsInf(bits, 1) {
+		return invalidOption("entropy credit must be finite and non-negative, not %g", bits)
+	}
+	return nil
+}
+
+// credit returns the entropy credit for the event data.  The argument
+// perEvent gives the default credit for sources which do not declare
+// their own credit, and limit is the maximal credit for one event.
+func (cfg *sinkConfig) credit(data []byte, perEvent, limit float64) float64 {
+	bits := perEvent
+	if cfg.estimate != nil {
+		bits = cfg.estimate(data)
+	}
+	if !(bits > 0) {
+		// also catches NaN
+		return 0
+	}
+	n := len(data)
+	if n > maxEventSize {
+		n = maxEventSize
+	}
+	if max := float64(8 * n); bits > max {
+		bits = max
+	}
+	if bits > limit {
+		bits = limit
+	}
+	return bits
+}
+
+// eventCreditLimit returns the maximal entropy credit for a single
+// event.  This is the value set by WithMaxEventCredit(), but at most
+// half of the entropy required for a reseed.
+func (cfg *config) eventCreditLimit() float64 {
+	limit := cfg.maxEventCredit
+	if half := cfg.minPoolEntropy / 2; limit > half {
+		limit = half
+	}
+	return limit
+}
+
+// creditSource adds one event with the given entropy credit to the
+// totals of src.
+func (acc *Accumulator) creditSource(src *source, bits float64) {
+	acc.sourceMutex.Lock()
+	src.Events++
+	src.Credit += bits
+	acc.sourceMutex.Unlock()
+}
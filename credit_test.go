@@ -0,0 +1,138 @@
+// credit_test.go - unit tests for credit.go
+// Copyright (C) 2013  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package fortuna
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestEntropyCredit(t *testing.T) {
+	acc, err := NewAccumulatorWithOptions(
+		WithPools(1),
+		WithMinPoolEntropy(64),
+		WithSinkBufferSize(0),
+		WithUnseededPolicy(UnseededError))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer acc.Close()
+
+	// The sinks are unbuffered, so after a send returns all earlier
+	// events from the same sink have been processed.
+
+	// Low quality data only counts 1 bit per byte, so 48 bytes are
+	// not enough to reseed.
+	weak, err := acc.NewSource("weak")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		buf := make([]byte, 16)
+		buf[0] = byte(i)
+		weak <- buf
+	}
+	if acc.reseedIfReady(); acc.IsSeeded() {
+		t.Fatal("reseeded from uncredited data")
+	}
+
+	// A source with a declared credit reaches the threshold.
+	strong, err := acc.NewSource("strong", WithEntropyCredit(16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	strong <- []byte{1, 2, 3, 4}
+	strong <- []byte{5, 6, 7, 8}
+	strong <- []byte{9, 10, 11, 12}
+	if acc.reseedIfReady(); !acc.IsSeeded() {
+		t.Error("not reseeded from credited data")
+	}
+
+	acc.Close()
+	stats := acc.Stats()
+	if stats.ClosedSources != 2 || stats.ClosedSourceEvents != 6 ||
+		stats.ClosedSourceCredit != 6*16 {
+		t.Errorf("wrong totals for closed sources: %+v", stats)
+	}
+}
+
+func TestEntropyEstimator(t *testing.T) {
+	acc, err := NewAccumulatorWithOptions(WithPools(1), WithSinkBufferSize(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer acc.Close()
+
+	estimates := []float64{1000, -1, math.NaN(), 2.5, 0}
+	i := 0
+	sink, err := acc.NewTimeStampSource("estimated",
+		WithEntropyEstimator(func(data []byte) float64 {
+			if len(data) != 8 {
+				t.Error("wrong event size", len(data))
+			}
+			res := estimates[i]
+			i++
+			return res
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Unix(1000000000, 0)
+	for k := range estimates {
+		sink <- start.Add(time.Duration(k*k) * time.Millisecond)
+	}
+	acc.Close()
+
+	stats := acc.Stats()
+	if stats.ClosedSourceEvents != 5 || stats.ClosedSourceCredit != 64+2.5 {
+		t.Errorf("estimates not applied correctly: %+v", stats)
+	}
+}
+
+func TestInvalidCredit(t *testing.T) {
+	acc, err := NewAccumulatorWithOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer acc.Close()
+
+	for _, bits := range []float64{-1, math.Inf(1), math.NaN()} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("invalid credit %g not detected", bits)
+				}
+			}()
+			acc.NewEntropyDataSink(WithEntropyCredit(bits))
+		}()
+
+		_, err := acc.NewSource("invalid", WithEntropyCredit(bits))
+		if !errors.Is(err, ErrInvalidOption) {
+			t.Errorf("invalid credit %g: wrong error %v", bits, err)
+		}
+	}
+	_, err = acc.NewTimeStampSource("invalid",
+		WithHealthConfig(HealthConfig{RepetitionCutoff: 1}))
+	if !errors.Is(err, ErrInvalidOption) {
+		t.Errorf("invalid health config: wrong error %v", err)
+	}
+	if n := len(acc.Sources()); n != 0 {
+		t.Errorf("%d sources registered despite invalid options", n)
+	}
+}
@@ -59,7 +59,35 @@
 // must be used in security sensitive applications.
 //
 // If a seed file is used, the Accumulator must be closed using the
-// Close() method after use.
+// Close() method after use.  While the Accumulator is in use, the
+// seed file is updated after the first reseed from the entropy pools
+// and then whenever enough output was generated or enough reseeds
+// happened; no updates happen while the Accumulator is idle.  The
+// SaveSeed() method can be used to force an update.
+//
+// The number of entropy pools, the reseed thresholds, the seed file
+// update interval and other settings can be changed by allocating the
+// Accumulator using NewAccumulatorWithOptions() instead:
+//
+//     rng, err := fortuna.NewAccumulatorWithOptions(
+//         fortuna.WithSeedFile(seedFileName),
+//         fortuna.WithPools(16),
+//         fortuna.WithReseedInterval(time.Second))
+//
+// Instead of a seed file, the seed can be kept in any SeedStore, for
+// example a MemorySeedStore, a DirSeedStore, or a custom
+// implementation backed by a database, using the WithSeedStore()
+// option.  The options WithSeedKey() and WithSeedPassphrase() encrypt
+// the stored seed, for cases where backups of the seed file are less
+// well protected than the running system.
+//
+// Seed files record a write counter and the machine and boot IDs of
+// the host.  This is used to detect seed files which were restored
+// from a backup or copied to a different host; WithRollbackPolicy()
+// determines whether such seed files are used, rejected, or followed
+// by a reseed from fresh operating system entropy.  The default seed
+// file keeps its write counter in the lock file next to it, so a
+// restore of both files together is not detected (see SeedCounter).
 //
 // Randomness can be extracted from the Accumulator using the
 // RandomData() and Read() methods.  For example, a slice of 16 random
@@ -67,6 +95,50 @@
 //
 //     data := rng.RandomData(16)
 //
+// Until the Accumulator has been reseeded from the entropy pools or
+// from a valid seed file, its output only depends on the initial
+// seed.  The IsSeeded() and WaitSeeded() methods can be used to check
+// for or wait for this condition, and the WithUnseededPolicy() option
+// makes Read() block or fail until the Accumulator is seeded.
+//
+// If the process forks, for example through C code linked via cgo,
+// the Accumulator detects this on the next request for random data
+// and reseeds the generator of the child process from fresh
+// operating system entropy, so that parent and child produce
+// different output.  See WithForkCanary() for an additional check on
+// Linux.
+//
+// The Stats() method returns a snapshot of the internal state of the
+// Accumulator, for example the number of reseeds, the amount of data
+// and entropy in each pool, and the state of the seed file.  This can
+// be used to check that entropy is flowing into the pools.  The
+// sub-package github.com/seehuhn/fortuna/metrics serves these values
+// over HTTP in the OpenMetrics text format, for Prometheus and
+// similar monitoring systems.
+//
+// Reseeds, reads and writes of the seed, the registration and
+// closing of entropy sources, health test failures and Close() can be
+// monitored by installing an Observer using the WithObserver()
+// option.  The events passed to an Observer never contain secret
+// material.
+//
+// The sub-package github.com/seehuhn/fortuna/collectors contains
+// ready-made entropy sources.  For example, collectors.NewSystem()
+// regularly samples interrupt counts, disk and network statistics and
+// process statistics on Linux and feeds them into the pools, and
+// collectors.NewJitter() measures CPU timing jitter, which does not
+// depend on the kernel random number generator.
+//
+// Diagnostic messages are passed to the trace package by default.
+// A *slog.Logger can be set using the WithLogger() option instead;
+// log records then carry structured attributes like the source ID,
+// the pool index and the reseed count.
+//
+// On Linux, the generator key and the state of the entropy pools are
+// kept outside the Go heap, in memory which is locked into RAM (so it
+// is never written to swap), excluded from core dumps and surrounded
+// by guard pages.  Close() overwrites this memory with zeros.
+//
 //
 // Entropy Pools
 //
@@ -88,17 +160,46 @@
 //         ...
 //     })
 //
+// Sources can also be given a name, using the NewSource() and
+// NewTimeStampSource() methods.  Names must be unique among the open
+// sources of an Accumulator and are used in health test failures.
+// Every source, named or not, is assigned a distinct ID which is
+// mixed into the pools together with the data, and the Sources()
+// method lists all open sources:
+//
+//     sink, err := rng.NewTimeStampSource("http-timing")
+//     if err != nil {
+//         ...
+//     }
+//
+// Every event is credited with an estimate of its entropy, in bits,
+// and the generator is reseeded once 256 bits have been credited to
+// pool 0 (see WithMinPoolEntropy()).  By default, data is credited
+// with 1 bit per byte and time stamps with 1 bit each.  Sources of
+// better quality can declare a higher credit using the
+// WithEntropyCredit() or WithEntropyEstimator() options.  The totals
+// for each open source are reported by Sources(), and the combined
+// totals of closed sources by Stats().  Events longer than 32
+// bytes are condensed using a keyed hash before they are added to a
+// pool, and the credit for a single event is limited (see
+// WithMaxEventCredit()), so that no single event can trigger a
+// reseed.
+//
 //
 // Generator
 //
 // The Generator class provides a pseudo random number generator which
 // forms the basis of the Accumulator described above.  New instances
 // of the Fortuna pseudo random number generator can be created using
-// the NewGenerator() function.  The argument newCipher should
-// normally be aes.NewCipher from the crypto/aes package, but the
-// Serpent or Twofish ciphers can also be used:
+// the NewGenerator() function:
+//
+//     gen := fortuna.NewGenerator()
+//
+// By default, the generator output is produced by the BLAKE2b
+// extendable output function.  The classic AES-256 in counter mode,
+// or ChaCha20, can be selected using the WithPrimitive() option:
 //
-//     gen := fortuna.NewGenerator(aes.NewCipher)
+//     gen := fortuna.NewGenerator(fortuna.WithPrimitive(fortuna.AESCTR{}))
 //
 // The generator can be seeded using the Seed() or Reseed() methods:
 //
@@ -17,50 +17,155 @@
 package fortuna
 
 import (
+	"errors"
 	"time"
 
-	"github.com/seehuhn/trace"
+	"golang.org/x/crypto/blake2b"
 
 	"encoding/binary"
 )
 
+// channelBufferSize is the default capacity of the channels returned
+// by NewEntropyDataSink() and NewEntropyTimeStampSink().
 const channelBufferSize = 4
 
+const (
+	// maxEventSize is the maximal length of event data which is
+	// mixed into the pools verbatim.  Longer events are condensed
+	// using eventHashKey.
+	maxEventSize = 32
+
+	// eventHashKey is the key for the BLAKE2b-256 hash used to
+	// condense long events.  The key separates this use of BLAKE2b
+	// from all other uses in the package.
+	eventHashKey = "fortuna long entropy event"
+)
+
 // addRandomEvent should be called periodically to add entropy to the
 // state of the random number generator.  Different sources of
 // randomness should use different values for the 'source' argument.
-// The .allocateSource() method can be used to allocate source
+// The .registerSource() method can be used to allocate source
 // numbers.
 //
 // The value 'seq' is used to spread out entropy over the available
 // entropy pools; for each entropy source, sequence values 0, 1, 2,
 // ... should be passed in.  Finally, the argument 'data' gives the
-// randomness to add to the pool.  'data' should be at most 32 bytes
-// long; longer values should be hashed by the caller and the hash be
-// submitted instead.
-func (acc *Accumulator) addRandomEvent(source uint8, seq uint, data []byte) {
-	pool := seq % numPools
+// randomness to add to the pool.  Data longer than 32 bytes is
+// condensed by mixEvent().  The event is credited with 8 bits for each
+// byte of data and for two bytes of event header, as in the original
+// description of Fortuna.
+func (acc *Accumulator) addRandomEvent(source uint64, seq uint, data []byte) {
+	acc.mixEvent(source, seq, data, float64(8*(2+len(data))))
+}
+
+// condenseEvent returns the data of an event as it is mixed into the
+// pools: events of up to maxEventSize bytes are used verbatim, longer
+// events are replaced by their keyed BLAKE2b-256 hash.
+func condenseEvent(data []byte) []byte {
+	if len(data) <= maxEventSize {
+		return data
+	}
+	h, _ := blake2b.New256([]byte(eventHashKey))
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// mixEvent adds the event data to the entropy pool selected by 'seq'.
+// Long events are first condensed using condenseEvent().  The new
+// pool state is the BLAKE2b-512 hash of the old pool state and the
+// event.  The amount of entropy 'credit', in bits, is added to the
+// total for the pool; the total for pool 0 triggers the next reseed.
+//
+// The source ID is encoded as an unsigned varint, followed by the
+// length of the data as a 4-byte integer.  Since varints are prefix
+// free, events from different sources can never be confused.  For
+// source IDs below 128 the encoding is the same as the single byte
+// used by earlier versions of the package.
+func (acc *Accumulator) mixEvent(source uint64, seq uint, data []byte, credit float64) {
+	pool := seq % uint(len(acc.pool))
+	size := len(data)
+	if len(data) > maxEventSize {
+		data = condenseEvent(data)
+		defer wipe(data)
+	}
 	acc.poolMutex.Lock()
 	defer acc.poolMutex.Unlock()
 
-	poolHash := acc.pool[pool]
-	poolHash.Write([]byte{source})
-	buf := make ([]byte, 4)
-	binary.BigEndian.PutUint32(buf, uint32(len(data)))
-	poolHash.Write(buf)
-	poolHash.Write(data)
-	if pool == 0 {
-		acc.poolZeroSize += 2 + len(data)
+	h := acc.poolHash
+	h.Write(acc.pool[pool])
+	buf := make([]byte, binary.MaxVarintLen64+4)
+	n := binary.PutUvarint(buf, source)
+	binary.BigEndian.PutUint32(buf[n:], uint32(len(data)))
+	h.Write(buf[:n+4])
+	h.Write(data)
+	h.Sum(acc.pool[pool][:0])
+	wipeHash(h)
+	acc.poolEntropy[pool] += credit
+	acc.poolBytes[pool] += int64(size)
+	if pool == 0 && acc.poolEntropy[0] >= acc.cfg.minPoolEntropy {
+		select {
+		case acc.poolReady <- struct{}{}:
+		default:
+		}
 	}
 }
 
-// allocateSource allocates a new source index for an entropy source.
-func (acc *Accumulator) allocateSource() uint8 {
+// healthCheck runs the health tests for one event of an entropy
+// source.  The return value indicates whether the sample should be
+// mixed into the pools and whether it counts towards the next
+// reseed.  The first failure of a source is reported using the
+// logger, the observers and the health failure handler.
+func (acc *Accumulator) healthCheck(src *source, health *HealthTest,
+	cfg *sinkConfig, data []byte) (use, count bool) {
+	if health.Failed() {
+		return !cfg.health.Disconnect, false
+	}
+	err := cfg.testSamples(health, data)
+	if err == nil {
+		return true, true
+	}
+
+	herr := err.(*HealthError)
+	herr.Source = src.ID
+	herr.SourceName = src.Name
+	acc.entropyLog.Error("health test failed",
+		logKeySource, src.ID, logKeyName, src.Name,
+		logKeyTest, herr.Test, logKeyCount, herr.Count, logKeyCutoff, herr.Cutoff)
 	acc.sourceMutex.Lock()
-	defer acc.sourceMutex.Unlock()
-	source := acc.nextSource
-	acc.nextSource++
-	return source
+	if acc.healthErr == nil {
+		acc.healthErr = herr
+	}
+	acc.healthFails++
+	acc.sourceMutex.Unlock()
+	if handler := acc.cfg.healthFailureHandler; handler != nil {
+		handler(herr)
+	}
+	acc.notify(&HealthEvent{Err: herr})
+	acc.flushEvents()
+	return !cfg.health.Disconnect, false
+}
+
+// newSinkConfig applies the sink options.  If the health test
+// settings, the sample size or the entropy credit are invalid, an error wrapping
+// ErrInvalidOption is returned.
+func newSinkConfig(opts []SinkOption) (*sinkConfig, error) {
+	cfg := &sinkConfig{
+		health: DefaultHealthConfig(),
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	if err := cfg.health.check(); err != nil {
+		return nil, err
+	}
+	if cfg.sampleSize < 0 {
+		return nil, invalidOption("health test sample size must not be negative, not %d",
+			cfg.sampleSize)
+	}
+	if cfg.creditErr != nil {
+		return nil, cfg.creditErr
+	}
+	return cfg, nil
 }
 
 // NewEntropyDataSink returns a channel through which data can be
@@ -74,19 +179,68 @@ func (acc *Accumulator) allocateSource() uint8 {
 //
 // If the data written to the channel is longer than 32 bytes, the
 // data is hashed internally and the hash is submitted to the entropy
-// pools instead of the data itself.
+// pools instead of the data itself.  The entropy credited for a single
+// value is limited, see WithMaxEventCredit(), so that one large value
+// cannot trigger a reseed on its own.
+//
+// Every submitted value is checked by the continuous health tests
+// from NIST SP 800-90B.  Once a test has failed, the data from this
+// sink no longer counts towards reseeding the generator.  The cutoff
+// values for the tests can be set using the WithHealthConfig()
+// option.  NewEntropyDataSink panics if one of the options is invalid;
+// use NewSource() to get an error instead.
 //
 // The channel can be closed by the caller to indicate that no more
 // entropy will be sent via this channel.
-func (acc *Accumulator) NewEntropyDataSink() chan<- []byte {
-	source := acc.allocateSource()
+func (acc *Accumulator) NewEntropyDataSink(opts ...SinkOption) chan<- []byte {
+	c, err := acc.newDataSource("", opts)
+	if errors.Is(err, ErrInvalidOption) {
+		panic(err)
+	} else if err != nil {
+		// Unnamed sources cannot be duplicates, so the Accumulator
+		// has been closed.  Return a channel which is never read,
+		// like earlier versions of the package.
+		return make(chan []byte, acc.cfg.sinkBufferSize)
+	}
+	return c
+}
+
+// NewSource registers a new, named entropy source and returns a
+// channel through which data can be submitted to the Accumulator's
+// entropy pools.  The channel works like the one returned by
+// NewEntropyDataSink().  The name identifies the source in
+// Sources() and in health test failures.  If another open source with
+// the same name exists, an error wrapping ErrDuplicateSource is
+// returned; once the channel of a source has been closed, its name
+// can be used again.  If one of the options is invalid, an error
+// wrapping ErrInvalidOption is returned.  If the Accumulator has been
+// closed, ErrClosed is returned.
+func (acc *Accumulator) NewSource(name string, opts ...SinkOption) (chan<- []byte, error) {
+	if name == "" {
+		return nil, invalidOption("entropy source name must not be empty")
+	}
+	return acc.newDataSource(name, opts)
+}
+
+func (acc *Accumulator) newDataSource(name string, opts []SinkOption) (chan<- []byte, error) {
+	cfg, err := newSinkConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+	src, err := acc.registerSource(name, cfg)
+	if err != nil {
+		return nil, err
+	}
+	acc.flushEvents()
 
-	c := make(chan []byte, channelBufferSize)
+	c := make(chan []byte, acc.cfg.sinkBufferSize)
 
-	acc.sources.Add(1)
 	go func() {
 		defer acc.sources.Done()
+		defer acc.flushEvents()
+		defer acc.closeSource(src)
 		seq := uint(0)
+		health := NewHealthTest(cfg.health)
 
 	loop:
 		for {
@@ -96,10 +250,19 @@ func (acc *Accumulator) NewEntropyDataSink() chan<- []byte {
 					break loop
 				}
 
-				trace.T("fortuna/entropy", trace.PrioDebug,
-					"adding %d bytes from source %d to pool %d",
-					len(data), source, seq%numPools)
-				acc.addRandomEvent(source, seq, data)
+				use, count := acc.healthCheck(src, health, cfg, data)
+				if !use {
+					continue
+				}
+
+				credit := 0.0
+				if count {
+					credit = cfg.credit(data, float64(defaultDataCredit*len(data)),
+						acc.cfg.eventCreditLimit())
+				}
+				acc.logEvent("adding data", src, seq, len(data), credit)
+				acc.mixEvent(src.ID, seq, data, credit)
+				acc.creditSource(src, credit)
 				seq++
 			case <-acc.stopSources:
 				break loop
@@ -107,7 +270,7 @@ func (acc *Accumulator) NewEntropyDataSink() chan<- []byte {
 		}
 	}()
 
-	return c
+	return c, nil
 }
 
 // NewEntropyTimeStampSink returns a channel through which timing data
@@ -118,18 +281,56 @@ func (acc *Accumulator) NewEntropyDataSink() chan<- []byte {
 // to an attacker.  Typical sources of randomness include the arrival
 // times of network packets or the times of key-presses by the user.
 //
+// The time differences between consecutive submissions are checked by
+// the same health tests as for NewEntropyDataSink().  In particular, a
+// sink which is fed with constant time differences is detected.
+//
 // The channel can be closed by the caller to indicate that no more
-// entropy will be sent via this channel.
-func (acc *Accumulator) NewEntropyTimeStampSink() chan<- time.Time {
-	source := acc.allocateSource()
+// entropy will be sent via this channel.  NewEntropyTimeStampSink
+// panics if one of the options is invalid; use NewTimeStampSource()
+// to get an error instead.
+func (acc *Accumulator) NewEntropyTimeStampSink(opts ...SinkOption) chan<- time.Time {
+	c, err := acc.newTimeStampSource("", opts)
+	if errors.Is(err, ErrInvalidOption) {
+		panic(err)
+	} else if err != nil {
+		return make(chan time.Time, acc.cfg.sinkBufferSize)
+	}
+	return c
+}
 
-	c := make(chan time.Time, channelBufferSize)
+// NewTimeStampSource registers a new, named entropy source for timing
+// data.  The returned channel works like the one returned by
+// NewEntropyTimeStampSink(), and names are handled as for
+// NewSource().
+func (acc *Accumulator) NewTimeStampSource(name string, opts ...SinkOption) (chan<- time.Time, error) {
+	if name == "" {
+		return nil, invalidOption("entropy source name must not be empty")
+	}
+	return acc.newTimeStampSource(name, opts)
+}
+
+func (acc *Accumulator) newTimeStampSource(name string, opts []SinkOption) (chan<- time.Time, error) {
+	cfg, err := newSinkConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+	cfg.sampleSize = 0 // every time difference is one sample
+	src, err := acc.registerSource(name, cfg)
+	if err != nil {
+		return nil, err
+	}
+	acc.flushEvents()
+
+	c := make(chan time.Time, acc.cfg.sinkBufferSize)
 
-	acc.sources.Add(1)
 	go func() {
 		defer acc.sources.Done()
+		defer acc.flushEvents()
+		defer acc.closeSource(src)
 		seq := uint(0)
-		lastRequest := time.Now()
+		lastRequest := acc.cfg.clock()
+		health := NewHealthTest(cfg.health)
 
 	loop:
 		for {
@@ -141,11 +342,21 @@ func (acc *Accumulator) NewEntropyTimeStampSink() chan<- time.Time {
 
 				dt := now.Sub(lastRequest)
 				lastRequest = now
+				data := int64ToBytes(int64(dt))
+
+				use, count := acc.healthCheck(src, health, cfg, data)
+				if !use {
+					continue
+				}
 
-				trace.T("fortuna/entropy", trace.PrioDebug,
-					"adding time stamp data from source %d to pool %d",
-					source, seq%numPools)
-				acc.addRandomEvent(source, seq, int64ToBytes(int64(dt)))
+				credit := 0.0
+				if count {
+					credit = cfg.credit(data, defaultTimeStampCredit,
+						acc.cfg.eventCreditLimit())
+				}
+				acc.logEvent("adding time stamp", src, seq, len(data), credit)
+				acc.mixEvent(src.ID, seq, data, credit)
+				acc.creditSource(src, credit)
 				seq++
 			case <-acc.stopSources:
 				break loop
@@ -153,5 +364,5 @@ func (acc *Accumulator) NewEntropyTimeStampSink() chan<- time.Time {
 		}
 	}()
 
-	return c
+	return c, nil
 }
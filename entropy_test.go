@@ -17,34 +17,104 @@
 package fortuna
 
 import (
+	"bytes"
 	"testing"
 	"time"
+
+	"golang.org/x/crypto/blake2b"
 )
 
 func TestPoolSelection(t *testing.T) {
 	acc, _ := NewRNG("")
 	sink := acc.NewEntropyDataSink()
 
+	// use different messages, so that the health tests pass
 	msg := []byte{0}
 	for i := 0; i < numPools+channelBufferSize+1; i++ {
-		sink <- msg
+		sink <- []byte{byte(i)}
 	}
 	acc.poolMutex.Lock()
-	size := acc.poolZeroSize
+	size := acc.poolEntropy[0]
 	acc.poolMutex.Unlock()
 
-	if size != 2*(2+len(msg)) {
+	if size != float64(2*defaultDataCredit*len(msg)) {
 		t.Error("distribution of events over pools failed")
 	}
 }
 
+func TestCondenseEvent(t *testing.T) {
+	short := make([]byte, maxEventSize)
+	if !bytes.Equal(condenseEvent(short), short) {
+		t.Error("short event modified")
+	}
+
+	long := make([]byte, 1000)
+	for i := range long {
+		long[i] = byte(i)
+	}
+	condensed := condenseEvent(long)
+	if len(condensed) != maxEventSize {
+		t.Fatal("wrong length of condensed event:", len(condensed))
+	}
+	plain := blake2b.Sum256(long)
+	if bytes.Equal(condensed, plain[:]) {
+		t.Error("condensing is not domain separated")
+	}
+
+	var states []string
+	for _, data := range [][]byte{long, condensed} {
+		acc, err := NewAccumulatorWithOptions(WithPools(1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		acc.addRandomEvent(0, 0, data)
+		states = append(states, string(acc.pool[0]))
+		acc.Close()
+	}
+	if states[0] != states[1] {
+		t.Error("long event not condensed before mixing")
+	}
+}
+
+func TestHugeEvent(t *testing.T) {
+	for _, limit := range []int{1, 64, 1000} {
+		acc, err := NewAccumulatorWithOptions(
+			WithPools(1),
+			WithMaxEventCredit(limit),
+			WithSinkBufferSize(0),
+			WithUnseededPolicy(UnseededError))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		sink, err := acc.NewSource("huge", WithEntropyCredit(1e6))
+		if err != nil {
+			t.Fatal(err)
+		}
+		huge := make([]byte, 1<<20)
+		huge[0] = 1
+		sink <- huge
+		for acc.Sources()[0].Events == 0 {
+			time.Sleep(time.Millisecond)
+		}
+
+		credit := acc.Sources()[0].Credit
+		if credit > float64(limit) || credit > 8*minPoolSize/2 {
+			t.Errorf("limit %d: huge event credited with %g bits", limit, credit)
+		}
+		if acc.reseedIfReady(); acc.IsSeeded() {
+			t.Errorf("limit %d: single event triggers a reseed", limit)
+		}
+		acc.Close()
+	}
+}
+
 func BenchmarkAddRandomEvent(b *testing.B) {
 	acc, _ := NewRNG("")
-	source := acc.allocateSource()
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		acc.addRandomEvent(source, uint(i), []byte{1, 2, 3, 4, 5, 6, 7, 8})
+		acc.addRandomEvent(0, uint(i), []byte{1, 2, 3, 4, 5, 6, 7, 8})
 	}
 }
 
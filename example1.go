@@ -22,24 +22,24 @@ import (
 	"crypto/rand"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
+	"os"
 	"strconv"
 	"time"
 
 	"github.com/seehuhn/fortuna"
-	"github.com/seehuhn/trace"
 )
 
 const seedFileName = "seed.dat"
 
-func printTrace(t time.Time, path string, prio trace.Priority, msg string) {
-	fmt.Printf("%s:%s: %s\n", t.Format("15:04:05.000"), path, msg)
-}
-
 func main() {
-	trace.Register(printTrace, "", trace.PrioDebug)
+	logger := slog.New(slog.NewTextHandler(os.Stdout,
+		&slog.HandlerOptions{Level: fortuna.LevelTrace}))
 
-	rng, err := fortuna.NewRNG(seedFileName)
+	rng, err := fortuna.NewAccumulatorWithOptions(
+		fortuna.WithSeedFile(seedFileName),
+		fortuna.WithLogger(logger))
 	if err != nil {
 		panic("cannot initialise the RNG: " + err.Error())
 	}
@@ -47,7 +47,7 @@ func main() {
 
 	// entropy source 1: submit some randomness from crypto/rand once a minute
 	go func() {
-		sink1 := rng.NewEntropyDataSink()
+		sink1 := rng.NewEntropyDataSink(fortuna.WithEntropyCredit(32))
 		for _ = range time.Tick(time.Minute) {
 			buffer := make([]byte, 4)
 			n, _ := rand.Read(buffer)
@@ -68,15 +68,13 @@ func main() {
 		w.Header().Set("Content-Length", fmt.Sprintf("%d", size))
 
 		io.CopyN(w, rng, size)
-		trace.T("main", trace.PrioInfo,
-			"sent %d random bytes for %q", size, r.RequestURI)
+		logger.Info("sent random bytes", "bytes", size, "uri", r.RequestURI)
 	})
 
 	listenAddr := ":8080"
-	trace.T("main", trace.PrioInfo,
-		"listening on http://localhost%s/", listenAddr)
+	logger.Info("listening on http://localhost" + listenAddr + "/")
 	err = http.ListenAndServe(listenAddr, nil)
 	if err != nil {
-		trace.T("main", trace.PrioCritical, "%s", err.Error())
+		logger.Error("server failed", "error", err)
 	}
 }
@@ -30,3 +30,12 @@ func flock(file *os.File) error {
 func funlock(file *os.File) error {
 	return nil
 }
+
+// syncDir is a dummy function which always returns nil on this
+// system.
+//
+// On Unix systems, syncDir() flushes the directory entries of dir to
+// disk, so that a file renamed into dir survives a crash.
+func syncDir(dir string) error {
+	return nil
+}
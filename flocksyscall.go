@@ -28,3 +28,15 @@ func flock(file *os.File) error {
 func funlock(file *os.File) error {
 	return syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
 }
+
+// syncDir flushes the directory entries of dir to disk, so that a
+// file renamed into dir survives a crash.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	d.Close()
+	return err
+}
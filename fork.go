@@ -0,0 +1,84 @@
+// fork.go - detection of process forks
+// Copyright (C) 2013  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package fortuna
+
+import (
+	"os"
+)
+
+// If a process forks, for example via C code linked using cgo, parent
+// and child share the complete state of the Accumulator.  Without
+// countermeasures, both processes would produce identical output.
+// The Accumulator therefore records the process ID and, optionally,
+// keeps a canary page which the kernel clears in the child process.
+// Both are checked before every request for random data.
+
+// WithForkCanary enables an additional check for forks of the
+// process: on Linux 4.14 and newer, a memory page marked with
+// MADV_WIPEONFORK is used, which is cleared by the kernel in a child
+// process.  This also detects forks where the child happens to get
+// the same process ID as the parent, for example in a new PID
+// namespace.  On other systems, or if the page cannot be allocated,
+// only the process ID is checked.
+func WithForkCanary() Option {
+	return func(cfg *config) error {
+		cfg.forkCanary = true
+		return nil
+	}
+}
+
+// initForkDetection records the process ID and sets up the canary
+// page, if requested.
+func (acc *Accumulator) initForkDetection() {
+	acc.pid = os.Getpid()
+	if acc.cfg.forkCanary {
+		canary, err := newForkCanary()
+		if err != nil {
+			acc.seedLog.Info("fork canary not available, using process ID only",
+				logKeyError, err)
+			return
+		}
+		acc.canary = canary
+	}
+}
+
+// checkFork reseeds the generator with fresh entropy from the
+// operating system if the process has forked since the last call.
+// The caller must hold acc.genMutex.
+func (acc *Accumulator) checkFork() {
+	pid := os.Getpid()
+	if pid == acc.pid && (acc.canary == nil || !acc.canary.forked()) {
+		return
+	}
+
+	acc.seedLog.Info("fork detected, reseeding",
+		logKeyPID, pid, logKeyOldPID, acc.pid)
+	acc.gen.setInitialSeed()
+	acc.pid = pid
+	if acc.canary != nil {
+		acc.canary.arm()
+	}
+	acc.forks++
+}
+
+// releaseForkDetection frees the canary page.
+func (acc *Accumulator) releaseForkDetection() {
+	if acc.canary != nil {
+		acc.canary.release()
+		acc.canary = nil
+	}
+}
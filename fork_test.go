@@ -0,0 +1,55 @@
+// fork_test.go - unit tests for fork.go
+// Copyright (C) 2013  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package fortuna
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+// forkedOutput returns the output of acc after simulating a fork
+// using simulate, together with the output the generator would have
+// produced without the fork.
+func forkedOutput(acc *Accumulator, simulate func()) (got, parent []byte) {
+	acc.genMutex.Lock()
+	snapshot := snapshotGenerator(acc.gen)
+	simulate()
+	acc.genMutex.Unlock()
+	return acc.RandomData(32), snapshot.PseudoRandomData(32)
+}
+
+func TestForkDetection(t *testing.T) {
+	acc, err := NewAccumulatorWithOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer acc.Close()
+
+	got, parent := forkedOutput(acc, func() {})
+	if bytes.Compare(got, parent) != 0 || acc.forks != 0 {
+		t.Fatal("reseed without fork")
+	}
+
+	got, parent = forkedOutput(acc, func() { acc.pid++ })
+	if bytes.Compare(got, parent) == 0 {
+		t.Error("child produces the same output as the parent")
+	}
+	if acc.forks != 1 || acc.pid != os.Getpid() {
+		t.Errorf("fork not recorded: %d forks, pid %d", acc.forks, acc.pid)
+	}
+}
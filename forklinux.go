@@ -0,0 +1,50 @@
+// +build linux
+
+package fortuna
+
+import (
+	"syscall"
+)
+
+// madvWipeOnFork is MADV_WIPEONFORK from <linux/mman.h>.  The
+// constant is missing from the syscall package.
+const madvWipeOnFork = 18
+
+// forkCanary is a memory page which the kernel clears in child
+// processes after a fork.
+type forkCanary struct {
+	page []byte
+}
+
+func newForkCanary() (*forkCanary, error) {
+	page, err := syscall.Mmap(-1, 0, syscall.Getpagesize(),
+		syscall.PROT_READ|syscall.PROT_WRITE,
+		syscall.MAP_PRIVATE|syscall.MAP_ANON)
+	if err != nil {
+		return nil, err
+	}
+	err = syscall.Madvise(page, madvWipeOnFork)
+	if err != nil {
+		syscall.Munmap(page)
+		return nil, err
+	}
+	c := &forkCanary{page: page}
+	c.arm()
+	return c, nil
+}
+
+// arm marks the page in the current process.
+func (c *forkCanary) arm() {
+	c.page[0] = 1
+}
+
+// forked returns true if the page has been cleared by a fork since
+// arm() was last called.
+func (c *forkCanary) forked() bool {
+	return c.page[0] == 0
+}
+
+func (c *forkCanary) release() {
+	syscall.Munmap(c.page)
+	c.page = nil
+}
@@ -0,0 +1,27 @@
+// +build linux
+
+package fortuna
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestForkCanary(t *testing.T) {
+	acc, err := NewAccumulatorWithOptions(WithForkCanary())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer acc.Close()
+	if acc.canary == nil {
+		t.Skip("fork canary not available")
+	}
+
+	got, parent := forkedOutput(acc, func() { acc.canary.page[0] = 0 })
+	if bytes.Compare(got, parent) == 0 {
+		t.Error("child produces the same output as the parent")
+	}
+	if acc.forks != 1 || acc.canary.forked() {
+		t.Error("fork not recorded")
+	}
+}
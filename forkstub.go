@@ -0,0 +1,23 @@
+// +build !linux
+
+package fortuna
+
+import (
+	"errors"
+)
+
+// forkCanary is not available on this system.
+type forkCanary struct{}
+
+// newForkCanary is a dummy function which always returns an error on
+// this system.
+//
+// On Linux, newForkCanary() allocates a memory page which the kernel
+// clears in child processes after a fork.
+func newForkCanary() (*forkCanary, error) {
+	return nil, errors.New("MADV_WIPEONFORK not supported")
+}
+
+func (c *forkCanary) arm()         {}
+func (c *forkCanary) forked() bool { return false }
+func (c *forkCanary) release()     {}
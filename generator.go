@@ -22,22 +22,13 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"log/slog"
 	"net"
 	"os/user"
 	"strings"
 	"time"
-
-	"github.com/seehuhn/trace"
-
-	"golang.org/x/crypto/blake2b"
 )
 
-
-var NewXOF = func() blake2b.XOF {
-	xof, _ := blake2b.NewXOF(0, nil)
-	return xof
-}
-
 // Generator holds the state of one instance of the Fortuna pseudo
 // random number generator.  Before use, the generator must be seeded
 // using the Reseed() or Seed() method.  Randomness can then be
@@ -48,22 +39,58 @@ var NewXOF = func() blake2b.XOF {
 // If the generator is accessed from different Go-routines, the
 // callers must synchronise access using sync.Mutex or similar.
 type Generator struct {
-	key []byte
-	xof blake2b.XOF
+	prim Primitive
+	mem  *lockedBuffer
+	key  []byte
+
+	logger  *slog.Logger // set by WithGeneratorLogger()
+	log     *slog.Logger
+	seedLog *slog.Logger
+
+	optErr error // the first error from a GeneratorOption
 }
 
+// GeneratorOption is the type of the optional arguments of
+// NewGenerator().
+type GeneratorOption func(gen *Generator)
 
-func (gen *Generator) setKey(key []byte) {
+// WithPrimitive selects the Primitive used by a Generator to produce
+// output and to derive new keys.  The default is BLAKE2bXOF{}.  The
+// primitive must not be nil.
+func WithPrimitive(prim Primitive) GeneratorOption {
+	return func(gen *Generator) {
+		if prim == nil {
+			gen.setOptErr(invalidOption("primitive must not be nil"))
+			return
+		}
+		gen.prim = prim
+	}
+}
 
-	xof := NewXOF()
-	xof.Write(gen.key)
-	xof.Write(key)
+// setOptErr records an invalid GeneratorOption.  Only the first
+// error is kept.
+func (gen *Generator) setOptErr(err error) {
+	if gen.optErr == nil {
+		gen.optErr = err
+	}
+}
 
-	newKey := make([]byte, 32)
-	xof.Read(newKey)
-	gen.key = newKey //first 32 bytes of output are the new key
+const (
+	// keySize is the length of the generator key in bytes.
+	keySize = 32
+
+	// maxRekeyInterval is the maximal number of output bytes which
+	// are generated from the same key.  Longer requests are split
+	// into several parts, with a new key being set after every part.
+	maxRekeyInterval = 1 << 20
+)
 
-	gen.xof = xof
+// setKey replaces the generator key.  The key is kept in locked
+// memory (see lockedBuffer); the argument is wiped after it has been
+// copied.
+func (gen *Generator) setKey(key []byte) {
+	copy(gen.key, key)
+	wipe(key)
 }
 
 // setInitialSeed sets the initial seed for the Generator.  An
@@ -136,36 +163,88 @@ func (gen *Generator) setInitialSeed() {
 		sources = append(sources, "account details")
 	}
 
-	trace.T("fortuna/seed", trace.PrioInfo,
-		"initial seed based on "+strings.Join(sources, ", "))
+	gen.seedLog.Info("initial seed set",
+		logKeySeedFrom, strings.Join(sources, ", "))
 	buf := seedData.Bytes()
 	gen.Reseed(buf)
 	wipe(buf)
 }
 
 // NewGenerator creates a new instance of the Fortuna pseudo random
-// number generator.  The function newCipher should normally be
-// aes.NewCipher from the crypto/aes package, but the Serpent or
-// Twofish ciphers can also be used.
+// number generator.  By default the generator uses the BLAKE2bXOF
+// primitive; a different primitive can be selected using the
+// WithPrimitive() option:
+//
+//	gen := fortuna.NewGenerator(fortuna.WithPrimitive(fortuna.AESCTR{}))
 //
 // The initial seed is chosen based on the current time, the current
 // user name, the currently installed network interfaces and
 // randomness from the system random number generator.
-func NewGenerator() *Generator {
-	gen := &Generator{}
-	gen.reset()
+//
+// Log messages are passed to the trace package, unless a different
+// logger is set using the WithGeneratorLogger() option.
+//
+// Before the generator is seeded, the primitive is checked against
+// known answers.  If this self-test fails, NewGenerator panics with a
+// *SelfTestError; if an option is invalid, NewGenerator panics with an
+// error wrapping ErrInvalidOption.  Use NewGeneratorChecked() to get
+// the error returned instead.
+func NewGenerator(opts ...GeneratorOption) *Generator {
+	gen, err := NewGeneratorChecked(opts...)
+	if err != nil {
+		panic(err)
+	}
+	return gen
+}
+
+// NewGeneratorChecked creates a new instance of the Fortuna pseudo
+// random number generator, like NewGenerator().  If an option is
+// invalid, an error wrapping ErrInvalidOption is returned.  If the
+// self-test of the primitive fails, a *SelfTestError (wrapping
+// ErrSelfTest) is returned.
+func NewGeneratorChecked(opts ...GeneratorOption) (*Generator, error) {
+	gen, err := newGenerator(opts)
+	if err != nil {
+		return nil, err
+	}
+	err = selfTest(gen.prim)
+	if err != nil {
+		gen.mem.free()
+		return nil, err
+	}
 	gen.setInitialSeed()
 
-	return gen
+	return gen, nil
+}
+
+// newGenerator allocates a new, unseeded Generator.  If one of the
+// options is invalid, an error wrapping ErrInvalidOption is returned.
+func newGenerator(opts []GeneratorOption) (*Generator, error) {
+	gen := &Generator{
+		prim:   BLAKE2bXOF{},
+		logger: defaultLogger,
+	}
+	for _, opt := range opts {
+		opt(gen)
+	}
+	if gen.optErr != nil {
+		return nil, gen.optErr
+	}
+	gen.log = componentLogger(gen.logger, "generator")
+	gen.seedLog = componentLogger(gen.logger, "seed")
+	gen.mem = newLockedBuffer(keySize, componentLogger(gen.logger, "memory"))
+	gen.key = gen.mem.data
+	gen.reset()
+	return gen, nil
 }
 
 // reset reverts the generator to the unseeded state.  A new seed must
 // be set using the .Reseed() or .Seed() methods before the generator
 // can be used again.  This is mostly useful for unit testing, to
-// start the PRNG from a known state.
+// start the PRNG from a known state.  Since the unseeded state uses
+// an all-zero key, reset also wipes the key from memory.
 func (gen *Generator) reset() {
-	gen.key = nil
-	gen.setKey(make([]byte, 32))
+	gen.mem.wipe()
 }
 
 // Reseed uses the current generator state and the given seed value to
@@ -176,8 +255,8 @@ func (gen *Generator) reset() {
 // This is like the ReseedInt64() method, but the seed is given as a
 // byte slice instead of as an int64.
 func (gen *Generator) Reseed(seed []byte) {
-	gen.setKey(seed)
-	trace.T("fortuna/generator", trace.PrioVerbose, "seed updated")
+	gen.setKey(gen.prim.DeriveKey(gen.key, seed))
+	gen.log.Debug("seed updated")
 }
 
 // ReseedInt64 uses the current generator state and the given seed
@@ -192,13 +271,33 @@ func (gen *Generator) ReseedInt64(seed int64) {
 	gen.Reseed(bytes)
 }
 
-
 // PseudoRandomData returns a slice of n pseudo-random bytes.  The
 // result can be used as a replacement for a sequence of n uniformly
 // distributed and independent bytes.
+//
+// After the output has been generated, a new key is derived from the
+// output stream and the old key is overwritten, so that a compromise
+// of the generator state does not reveal any previous output.  The
+// output stream only exists for the duration of the call; between
+// calls, the generator state consists of the key alone.
 func (gen *Generator) PseudoRandomData(n uint) []byte {
 	res := make([]byte, n)
-	gen.xof.Read(res)
+	buf := res
+	for {
+		chunk := buf
+		if len(chunk) > maxRekeyInterval {
+			chunk = chunk[:maxRekeyInterval]
+		}
+		stream := gen.prim.NewStream(gen.key)
+		stream.Read(chunk)
+		stream.Read(gen.key)
+		stream.Wipe()
+
+		buf = buf[len(chunk):]
+		if len(buf) == 0 {
+			break
+		}
+	}
 	return res
 }
 
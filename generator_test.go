@@ -34,7 +34,7 @@ func TestOutput(t *testing.T) {
 	rng.Reseed([]byte{1, 2, 3, 4})
 	out := rng.PseudoRandomData(100)
 	correct := []byte{
-		46, 144, 196, 54, 218, 98, 33, 223, 85, 107, 31, 195, 208, 196, 44, 236, 200, 13, 161, 36, 66, 27, 116, 152, 116, 124, 190, 193, 121, 57, 9, 223, 236, 171, 174, 250, 217, 84, 5, 176, 47, 183, 112, 200, 41, 187, 138, 244, 165, 202, 240, 95, 75, 116, 232, 233, 115, 28, 29, 212, 3, 156, 128, 86, 49, 231, 167, 210, 156, 52, 76, 91, 236, 229, 253, 143, 141, 175, 217, 167, 247, 53, 81, 127, 192, 246, 242, 35, 75, 149, 42, 145, 208, 111, 169, 175, 188, 19, 18, 20,
+		83, 145, 227, 40, 235, 192, 240, 129, 162, 138, 72, 145, 238, 101, 250, 216, 99, 4, 196, 65, 123, 214, 69, 149, 153, 146, 111, 98, 178, 186, 187, 237, 160, 197, 234, 115, 174, 50, 78, 154, 54, 106, 228, 126, 119, 133, 95, 157, 209, 235, 109, 76, 80, 166, 44, 222, 136, 104, 137, 62, 222, 87, 30, 183, 1, 42, 250, 254, 40, 111, 57, 136, 50, 225, 141, 153, 233, 108, 64, 221, 22, 208, 84, 187, 140, 96, 138, 132, 205, 180, 207, 253, 224, 155, 230, 78, 100, 27, 137, 49,
 	}
 	if bytes.Compare(out, correct) != 0 {
 		t.Error("wrong RNG output", out)
@@ -42,7 +42,7 @@ func TestOutput(t *testing.T) {
 
 	out = rng.PseudoRandomData(1<<20 + 100)[1<<20:]
 	correct = []byte{
-		215, 192, 214, 200, 94, 19, 31, 195, 34, 172, 112, 8, 137, 103, 83, 219, 242, 202, 22, 235, 178, 71, 96, 53, 140, 184, 238, 19, 230, 102, 38, 192, 235, 111, 125, 217, 83, 214, 153, 19, 171, 28, 123, 33, 229, 129, 39, 140, 254, 201, 184, 81, 1, 157, 92, 100, 62, 194, 101, 201, 158, 146, 249, 131, 66, 105, 9, 60, 24, 155, 0, 154, 157, 37, 93, 123, 103, 23, 119, 115, 25, 225, 28, 33, 198, 43, 22, 85, 22, 224, 239, 247, 12, 157, 224, 78, 92, 110, 207, 52,
+		112, 165, 181, 206, 109, 25, 2, 102, 119, 28, 216, 167, 248, 92, 29, 124, 59, 193, 210, 130, 109, 247, 108, 161, 186, 252, 57, 17, 175, 185, 234, 124, 173, 217, 71, 112, 28, 219, 212, 140, 51, 49, 102, 205, 252, 36, 227, 171, 149, 121, 91, 253, 112, 156, 100, 64, 203, 240, 246, 27, 206, 145, 254, 56, 191, 100, 241, 228, 250, 226, 171, 74, 143, 131, 86, 100, 226, 44, 83, 206, 53, 217, 217, 3, 3, 17, 150, 74, 152, 203, 225, 218, 27, 69, 234, 252, 11, 174, 222, 31,
 	}
 	if bytes.Compare(out, correct) != 0 {
 		t.Error("wrong RNG output", out)
@@ -51,7 +51,7 @@ func TestOutput(t *testing.T) {
 	rng.Reseed([]byte{5})
 	out = rng.PseudoRandomData(100)
 	correct = []byte{
-		192, 254, 179, 2, 112, 177, 104, 141, 191, 224, 158, 71, 244, 238, 200, 171, 197, 233, 76, 0, 54, 28, 180, 85, 177, 159, 251, 9, 65, 253, 24, 182, 50, 179, 94, 164, 210, 249, 193, 41, 72, 221, 39, 251, 129, 167, 213, 238, 245, 142, 231, 60, 26, 148, 151, 14, 62, 184, 167, 144, 217, 231, 122, 71, 103, 174, 211, 196, 174, 253, 12, 6, 200, 5, 62, 167, 61, 179, 97, 32, 17, 82, 12, 146, 229, 109, 225, 43, 91, 155, 142, 240, 28, 88, 249, 178, 126, 79, 86, 49,
+		101, 46, 28, 10, 51, 177, 136, 203, 41, 165, 42, 116, 186, 217, 194, 156, 123, 182, 228, 64, 64, 152, 233, 91, 233, 194, 0, 49, 189, 144, 105, 94, 210, 58, 253, 241, 189, 205, 33, 199, 138, 200, 197, 236, 180, 54, 45, 121, 44, 113, 3, 231, 161, 164, 212, 78, 171, 87, 101, 251, 211, 188, 51, 39, 129, 191, 30, 234, 133, 14, 252, 140, 255, 119, 27, 159, 203, 17, 174, 224, 88, 71, 218, 106, 85, 55, 36, 133, 237, 63, 103, 206, 111, 92, 65, 10, 184, 171, 98, 140,
 	}
 	if bytes.Compare(out, correct) != 0 {
 		t.Error("wrong RNG output", out)
@@ -84,6 +84,61 @@ func TestSeed(t *testing.T) {
 	}
 }
 
+// snapshotGenerator returns a copy of a Generator.  Between calls
+// to PseudoRandomData, the key is the complete generator state.
+func snapshotGenerator(gen *Generator) *Generator {
+	res, _ := newGenerator([]GeneratorOption{WithPrimitive(gen.prim)})
+	copy(res.key, gen.key)
+	return res
+}
+
+func TestForwardSecrecy(t *testing.T) {
+	rng := NewGenerator()
+	rng.Seed(1)
+
+	oldKey := append([]byte{}, rng.key...)
+	out := rng.PseudoRandomData(1000)
+
+	// Capture the complete generator state after the request.
+	snapshot := snapshotGenerator(rng)
+	if bytes.Compare(snapshot.key, oldKey) == 0 {
+		t.Error("key not changed by PseudoRandomData")
+	}
+
+	// The snapshot determines all future output ...
+	next := rng.PseudoRandomData(100)
+	if bytes.Compare(snapshot.PseudoRandomData(100), next) != 0 {
+		t.Fatal("snapshot does not capture the generator state")
+	}
+
+	// ... but does not allow to reproduce the earlier output.
+	snapshot = snapshotGenerator(rng)
+	later := snapshot.PseudoRandomData(1 << 16)
+	if bytes.Contains(later, out[:16]) || bytes.Contains(later, out[len(out)-16:]) {
+		t.Error("earlier output reproduced from state snapshot")
+	}
+	for _, x := range [][]byte{snapshot.key, rng.key} {
+		if bytes.Contains(out, x) {
+			t.Error("key revealed in earlier output")
+		}
+	}
+}
+
+func TestLongRequest(t *testing.T) {
+	rng := NewGenerator()
+	rng.Seed(2)
+	long := rng.PseudoRandomData(maxRekeyInterval + 100)
+
+	// A long request must be equivalent to rekeying after every
+	// maxRekeyInterval bytes of output.
+	rng.Seed(2)
+	rng.PseudoRandomData(maxRekeyInterval)
+	tail := rng.PseudoRandomData(100)
+	if bytes.Compare(long[maxRekeyInterval:], tail) != 0 {
+		t.Error("long request not split at maxRekeyInterval")
+	}
+}
+
 func TestPrng(t *testing.T) {
 	rng := NewGenerator()
 	rng.Seed(123)
@@ -0,0 +1,266 @@
+// health.go - continuous health tests for entropy sources
+// Copyright (C) 2013  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package fortuna
+
+import (
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// ErrHealthTest is wrapped by all errors which report the failure of
+// a health test for an entropy source.
+var ErrHealthTest = errors.New("entropy source failed health test")
+
+// HealthConfig gives the cutoff values for the continuous health
+// tests from section 4.4 of NIST SP 800-90B, which are run on every
+// sample submitted to an entropy sink.
+type HealthConfig struct {
+	// RepetitionCutoff is the cutoff value C of the Repetition Count
+	// Test: the test fails if the same sample is seen C times in a
+	// row.  A value of 0 disables the test.
+	RepetitionCutoff int
+
+	// AdaptiveWindow is the window size W of the Adaptive Proportion
+	// Test.  A value of 0 disables the test.
+	AdaptiveWindow int
+
+	// AdaptiveCutoff is the cutoff value C of the Adaptive Proportion
+	// Test: the test fails if the first sample of a window occurs C
+	// times within the window.
+	AdaptiveCutoff int
+
+	// Disconnect indicates whether a source which failed a health
+	// test should be disconnected from the entropy pools.  If
+	// Disconnect is false, data from a failed source is still mixed
+	// into the pools, but no longer counts towards the amount of
+	// entropy required for reseeding the generator.
+	Disconnect bool
+}
+
+// DefaultHealthConfig returns the health test configuration used for
+// entropy sinks by default.  The cutoff values are the ones given in
+// SP 800-90B for sources with an assessed entropy of one bit per
+// sample and a false positive probability of 2^-20.
+func DefaultHealthConfig() HealthConfig {
+	return HealthConfig{
+		RepetitionCutoff: 21,
+		AdaptiveWindow:   512,
+		AdaptiveCutoff:   311,
+	}
+}
+
+func (cfg HealthConfig) check() error {
+	if cfg.RepetitionCutoff < 0 || cfg.RepetitionCutoff == 1 {
+		return invalidOption("repetition count cutoff must be 0 or at least 2, not %d",
+			cfg.RepetitionCutoff)
+	}
+	if cfg.AdaptiveWindow < 0 || cfg.AdaptiveWindow == 1 {
+		return invalidOption("adaptive proportion window must be 0 or at least 2, not %d",
+			cfg.AdaptiveWindow)
+	}
+	if cfg.AdaptiveWindow > 0 &&
+		(cfg.AdaptiveCutoff < 2 || cfg.AdaptiveCutoff > cfg.AdaptiveWindow) {
+		return invalidOption("adaptive proportion cutoff must be between 2 and %d, not %d",
+			cfg.AdaptiveWindow, cfg.AdaptiveCutoff)
+	}
+	return nil
+}
+
+// HealthError describes the failure of a health test for an entropy
+// source.
+type HealthError struct {
+	// Source is the ID of the failed entropy source, and SourceName
+	// is its name (empty for unnamed sources), see SourceInfo.
+	Source     uint64
+	SourceName string
+
+	// Test is the name of the failed test, either "repetition count"
+	// or "adaptive proportion".
+	Test string
+
+	// Count is the number of identical samples observed, and Cutoff
+	// is the configured cutoff value for the test.
+	Count, Cutoff int
+}
+
+func (err *HealthError) Error() string {
+	source := fmt.Sprintf("%d", err.Source)
+	if err.SourceName != "" {
+		source = fmt.Sprintf("%q", err.SourceName)
+	}
+	return fmt.Sprintf("entropy source %s failed %s test (%d/%d identical samples)",
+		source, err.Test, err.Count, err.Cutoff)
+}
+
+// Unwrap returns ErrHealthTest.
+func (err *HealthError) Unwrap() error {
+	return ErrHealthTest
+}
+
+// HealthTest runs the Repetition Count Test and the Adaptive
+// Proportion Test from NIST SP 800-90B on a sequence of samples.
+// Only hashes of the samples are retained, so that the test does not
+// keep copies of the submitted entropy in memory.
+type HealthTest struct {
+	cfg HealthConfig
+
+	last        [blake2b.Size256]byte
+	repeatCount int
+
+	first       [blake2b.Size256]byte
+	windowPos   int
+	windowCount int
+
+	failed *HealthError
+}
+
+// NewHealthTest allocates a new HealthTest with the given cutoff
+// values.
+func NewHealthTest(cfg HealthConfig) *HealthTest {
+	return &HealthTest{cfg: cfg}
+}
+
+// Sample submits the next sample to the health tests.  If one of the
+// tests fails, a *HealthError is returned.  Once a test has failed,
+// the same error is returned for all subsequent samples.
+func (h *HealthTest) Sample(sample []byte) error {
+	if h.failed != nil {
+		return h.failed
+	}
+	hash := blake2b.Sum256(sample)
+
+	if h.cfg.RepetitionCutoff > 0 {
+		if h.repeatCount > 0 && hash == h.last {
+			h.repeatCount++
+		} else {
+			h.last = hash
+			h.repeatCount = 1
+		}
+		if h.repeatCount >= h.cfg.RepetitionCutoff {
+			h.failed = &HealthError{
+				Test:   "repetition count",
+				Count:  h.repeatCount,
+				Cutoff: h.cfg.RepetitionCutoff,
+			}
+			return h.failed
+		}
+	}
+
+	if h.cfg.AdaptiveWindow > 0 {
+		if h.windowPos == 0 {
+			h.first = hash
+			h.windowCount = 1
+		} else if hash == h.first {
+			h.windowCount++
+		}
+		h.windowPos = (h.windowPos + 1) % h.cfg.AdaptiveWindow
+		if h.windowCount >= h.cfg.AdaptiveCutoff {
+			h.failed = &HealthError{
+				Test:   "adaptive proportion",
+				Count:  h.windowCount,
+				Cutoff: h.cfg.AdaptiveCutoff,
+			}
+			return h.failed
+		}
+	}
+
+	return nil
+}
+
+// Failed returns true if one of the health tests has failed.
+func (h *HealthTest) Failed() bool {
+	return h.failed != nil
+}
+
+// sinkConfig collects the settings of an entropy sink.
+type sinkConfig struct {
+	health     HealthConfig
+	sampleSize int
+	metadata   map[string]string
+	estimate   func(data []byte) float64
+	creditErr  error
+}
+
+// SinkOption is the type of the optional arguments of NewSource(),
+// NewTimeStampSource(), NewEntropyDataSink() and
+// NewEntropyTimeStampSink().
+type SinkOption func(cfg *sinkConfig)
+
+// WithHealthConfig sets the cutoff values for the health tests of an
+// entropy sink.  By default, DefaultHealthConfig() is used.
+func WithHealthConfig(health HealthConfig) SinkOption {
+	return func(cfg *sinkConfig) {
+		cfg.health = health
+	}
+}
+
+// WithSampleSize makes the health tests of a data source treat every
+// event as a sequence of samples of n bytes each, instead of as a
+// single sample.  This is useful for sources which submit several
+// measurements in one event.  If the length of an event is not a
+// multiple of n, the last sample is shorter.  By default, or if n is
+// 0, every event is one sample.  The option has no effect for time
+// stamp sources.
+func WithSampleSize(n int) SinkOption {
+	return func(cfg *sinkConfig) {
+		cfg.sampleSize = n
+	}
+}
+
+// testSamples submits the samples contained in the event data to the
+// health tests, see WithSampleSize().
+func (cfg *sinkConfig) testSamples(health *HealthTest, data []byte) error {
+	n := cfg.sampleSize
+	if n == 0 || n >= len(data) {
+		return health.Sample(data)
+	}
+	for len(data) > 0 {
+		k := n
+		if k > len(data) {
+			k = len(data)
+		}
+		if err := health.Sample(data[:k]); err != nil {
+			return err
+		}
+		data = data[k:]
+	}
+	return nil
+}
+
+// WithHealthFailureHandler sets a function which is called whenever
+// an entropy source fails a health test.  The function is called
+// from the goroutine which services the sink, and must not block.
+func WithHealthFailureHandler(handler func(err *HealthError)) Option {
+	return func(cfg *config) error {
+		cfg.healthFailureHandler = handler
+		return nil
+	}
+}
+
+// WithFatalHealthFailures makes the failure of a health test for any
+// entropy source fatal: once a source has failed, Read() and
+// ReadContext() return the corresponding *HealthError and
+// RandomData() panics.  By default, a failed source only stops
+// contributing to reseeds.
+func WithFatalHealthFailures() Option {
+	return func(cfg *config) error {
+		cfg.fatalHealthFailures = true
+		return nil
+	}
+}
@@ -0,0 +1,227 @@
+// health_test.go - unit tests for health.go
+// Copyright (C) 2013  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package fortuna
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRepetitionCount(t *testing.T) {
+	h := NewHealthTest(HealthConfig{RepetitionCutoff: 5})
+	for i := 0; i < 100; i++ {
+		// runs of length 4 are fine
+		if err := h.Sample([]byte{byte(i / 4)}); err != nil {
+			t.Fatal("unexpected failure:", err)
+		}
+	}
+	var err error
+	for i := 0; i < 5; i++ {
+		err = h.Sample([]byte{1, 2, 3})
+		if i < 4 && err != nil {
+			t.Fatal("premature failure:", err)
+		}
+	}
+	herr, ok := err.(*HealthError)
+	if !ok || herr.Test != "repetition count" || !errors.Is(err, ErrHealthTest) {
+		t.Fatal("repetition not detected:", err)
+	}
+	if h.Sample([]byte{4}) != err || !h.Failed() {
+		t.Error("failure is not permanent")
+	}
+}
+
+func TestAdaptiveProportion(t *testing.T) {
+	h := NewHealthTest(HealthConfig{
+		RepetitionCutoff: 5,
+		AdaptiveWindow:   16,
+		AdaptiveCutoff:   10,
+	})
+	var err error
+	for i := 0; i < 16 && err == nil; i++ {
+		// pattern A A A B: no long runs, but A is too frequent
+		sample := []byte{1}
+		if i%4 == 3 {
+			sample[0] = byte(i)
+		}
+		err = h.Sample(sample)
+	}
+	herr, ok := err.(*HealthError)
+	if !ok || herr.Test != "adaptive proportion" {
+		t.Error("skewed source not detected:", err)
+	}
+
+	h = NewHealthTest(DefaultHealthConfig())
+	for i := 0; i < 2000; i++ {
+		if err := h.Sample([]byte{byte(i), byte(i >> 8)}); err != nil {
+			t.Fatal("unexpected failure:", err)
+		}
+	}
+}
+
+func TestHealthConfigCheck(t *testing.T) {
+	if err := DefaultHealthConfig().check(); err != nil {
+		t.Error(err)
+	}
+	for _, cfg := range []HealthConfig{
+		{RepetitionCutoff: 1},
+		{AdaptiveWindow: 10, AdaptiveCutoff: 11},
+		{AdaptiveWindow: -1},
+	} {
+		if err := cfg.check(); !errors.Is(err, ErrInvalidOption) {
+			t.Errorf("invalid config %v not detected", cfg)
+		}
+	}
+}
+
+func TestStuckTimeStampSink(t *testing.T) {
+	start := time.Unix(1000000000, 0)
+	failures := make(chan *HealthError, 1)
+	acc, err := NewAccumulatorWithOptions(
+		WithPools(1),
+		WithSinkBufferSize(0),
+		WithClock(func() time.Time { return start }),
+		WithHealthFailureHandler(func(err *HealthError) {
+			failures <- err
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer acc.Close()
+
+	poolZeroEntropy := func() float64 {
+		acc.poolMutex.Lock()
+		defer acc.poolMutex.Unlock()
+		return acc.poolEntropy[0]
+	}
+
+	cutoff := DefaultHealthConfig().RepetitionCutoff
+	sink := acc.NewEntropyTimeStampSink()
+	for i := 1; i <= cutoff; i++ {
+		sink <- start.Add(time.Duration(i) * time.Millisecond)
+	}
+	herr := <-failures
+	if herr.Source != 0 || herr.Test != "repetition count" {
+		t.Errorf("wrong failure reported: %s", herr)
+	}
+	before := poolZeroEntropy()
+	if before != float64((cutoff-1)*defaultTimeStampCredit) {
+		t.Errorf("wrong amount of entropy counted: %g", before)
+	}
+
+	// The sink is unbuffered, so after the last send returns all
+	// earlier time stamps have been processed.
+	for i := 0; i < 100; i++ {
+		sink <- time.Unix(int64(i*i), 0)
+	}
+	sink <- time.Unix(0, 0)
+	if poolZeroEntropy() != before {
+		t.Error("failed source still counts towards reseeding")
+	}
+}
+
+func TestDisconnectedSink(t *testing.T) {
+	acc, err := NewAccumulatorWithOptions(WithPools(1), WithSinkBufferSize(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer acc.Close()
+
+	sink := acc.NewEntropyDataSink(WithHealthConfig(HealthConfig{
+		RepetitionCutoff: 2,
+		Disconnect:       true,
+	}))
+	sink <- []byte{1}
+	sink <- []byte{1}
+	sink <- []byte{2}
+	sink <- []byte{3}
+
+	acc.poolMutex.Lock()
+	reference := string(acc.poolMem.data)
+	acc.poolMutex.Unlock()
+	sink <- []byte{4}
+	acc.poolMutex.Lock()
+	defer acc.poolMutex.Unlock()
+
+	if string(acc.poolMem.data) != reference {
+		t.Error("disconnected source still modifies the pools")
+	}
+	if acc.poolEntropy[0] != defaultDataCredit {
+		t.Error("wrong amount of entropy counted:", acc.poolEntropy[0])
+	}
+}
+
+func TestSampleSize(t *testing.T) {
+	acc, err := NewAccumulatorWithOptions(WithPools(1), WithSinkBufferSize(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer acc.Close()
+
+	_, err = acc.NewSource("negative", WithSampleSize(-1))
+	if !errors.Is(err, ErrInvalidOption) {
+		t.Errorf("negative sample size: wrong error %v", err)
+	}
+
+	health := WithHealthConfig(HealthConfig{RepetitionCutoff: 3})
+	whole, err := acc.NewSource("whole", health)
+	if err != nil {
+		t.Fatal(err)
+	}
+	split, err := acc.NewSource("split", health, WithSampleSize(8))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// three identical measurements of 8 bytes each
+	data := bytes.Repeat([]byte{1, 2, 3, 4, 5, 6, 7, 8}, 3)
+	whole <- data
+	whole <- data
+	if n := acc.Stats().HealthFailures; n != 0 {
+		t.Errorf("%d failures for whole events", n)
+	}
+	split <- data
+	split <- []byte{0}
+	if n := acc.Stats().HealthFailures; n != 1 {
+		t.Errorf("%d failures for split events", n)
+	}
+	for _, src := range acc.Sources() {
+		if src.Name == "split" && src.Credit != 0 {
+			t.Errorf("failed event credited with %g bits", src.Credit)
+		}
+	}
+}
+
+func TestDefaultHealthConfig(t *testing.T) {
+	// Two thirds of the samples are identical.  This is too many for
+	// a source with one bit of entropy per sample.
+	h := NewHealthTest(DefaultHealthConfig())
+	var err error
+	for i := 0; i < 512 && err == nil; i++ {
+		sample := []byte{0}
+		if i%3 == 2 {
+			sample[0] = byte(i)
+		}
+		err = h.Sample(sample)
+	}
+	herr, ok := err.(*HealthError)
+	if !ok || herr.Test != "adaptive proportion" || herr.Count != 311 {
+		t.Errorf("wrong error %v", err)
+	}
+}
@@ -0,0 +1,38 @@
+// +build linux
+
+package fortuna
+
+import (
+	"encoding/hex"
+	"io/ioutil"
+	"strings"
+)
+
+// readHostID returns the machine ID from /etc/machine-id and the boot
+// ID from /proc/sys/kernel/random/boot_id.  IDs which cannot be read
+// are left as zeros.
+func readHostID() hostID {
+	var id hostID
+	for _, name := range []string{"/etc/machine-id", "/var/lib/dbus/machine-id"} {
+		if parseID(id.machine[:], name) {
+			break
+		}
+	}
+	parseID(id.boot[:], "/proc/sys/kernel/random/boot_id")
+	return id
+}
+
+// parseID reads a 128 bit ID, written as 32 hex digits with optional
+// dashes, from the file fileName into dst.
+func parseID(dst []byte, fileName string) bool {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return false
+	}
+	text := strings.Replace(strings.TrimSpace(string(data)), "-", "", -1)
+	if hex.DecodedLen(len(text)) != len(dst) {
+		return false
+	}
+	_, err = hex.Decode(dst, []byte(text))
+	return err == nil
+}
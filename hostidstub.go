@@ -0,0 +1,12 @@
+// +build !linux
+
+package fortuna
+
+// readHostID is a dummy function which always returns zero IDs on
+// this system.
+//
+// On Linux, readHostID() returns the machine ID and the boot ID of the
+// running system.
+func readHostID() hostID {
+	return hostID{}
+}
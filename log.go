@@ -0,0 +1,243 @@
+// log.go - structured logging for the Accumulator and the Generator
+// Copyright (C) 2013  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package fortuna
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/seehuhn/trace"
+)
+
+// ComponentKey is the key of the attribute which names the part of
+// the package a log record comes from.  The values are "seed" (seeding,
+// reseeding and the seed file), "entropy" (the entropy sources),
+// "generator" and "memory" (the locked memory for secrets).
+const ComponentKey = "component"
+
+// Besides the standard slog levels, the package uses the following
+// log levels.
+const (
+	// LevelCritical is used for failures which make the random number
+	// generator unusable, for example a failed self-test.
+	LevelCritical = slog.LevelError + 4
+
+	// LevelTrace is used for every event submitted by an entropy
+	// source.  Messages at this level are very frequent and are
+	// discarded by the default handler, see NewTraceHandler().
+	LevelTrace = slog.LevelDebug - 4
+)
+
+// Log records use the following attribute keys, in addition to
+// ComponentKey.
+const (
+	logKeyError    = "error"
+	logKeySource   = "source"
+	logKeyName     = "source_name"
+	logKeyPool     = "pool"
+	logKeyPools    = "pools"
+	logKeyReseed   = "reseed"
+	logKeyBytes    = "bytes"
+	logKeyCredit   = "credit_bits"
+	logKeyPath     = "path"
+	logKeyReason   = "reason"
+	logKeyVersion  = "version"
+	logKeyCounter  = "counter"
+	logKeyTest     = "test"
+	logKeyCount    = "count"
+	logKeyCutoff   = "cutoff"
+	logKeyPID      = "pid"
+	logKeyOldPID   = "old_pid"
+	logKeySeedFrom = "seed_sources"
+)
+
+// WithLogger sets the logger for an Accumulator and its Generator.
+// The logger is also used by a FileSeedStore or DirSeedStore attached
+// to the Accumulator.  Log records carry structured attributes, like
+// the source ID, the pool index or the reseed count, and the
+// ComponentKey attribute.
+//
+// By default, log records are passed to the trace package, see
+// NewTraceHandler().
+func WithLogger(logger *slog.Logger) Option {
+	return func(cfg *config) error {
+		if logger == nil {
+			return invalidOption("logger must not be nil")
+		}
+		cfg.logger = logger
+		return nil
+	}
+}
+
+// WithGeneratorLogger sets the logger for a Generator.  When used
+// through WithGeneratorOptions(), this overrides the logger set by
+// WithLogger() for the Generator of an Accumulator.
+//
+// By default, log records are passed to the trace package, see
+// NewTraceHandler().  A nil logger is ignored.
+func WithGeneratorLogger(logger *slog.Logger) GeneratorOption {
+	return func(gen *Generator) {
+		if logger != nil {
+			gen.logger = logger
+		}
+	}
+}
+
+// defaultLogger is used unless a different logger is set using
+// WithLogger() or WithGeneratorLogger().
+var defaultLogger = slog.New(NewTraceHandler(nil))
+
+// componentLogger returns a logger which adds the ComponentKey
+// attribute with the given value to all records.
+func componentLogger(logger *slog.Logger, component string) *slog.Logger {
+	return logger.With(ComponentKey, component)
+}
+
+// NewTraceHandler returns a slog.Handler which passes log records to
+// trace.T().  The trace path is "fortuna/" followed by the value of
+// the ComponentKey attribute, so that existing trace listeners for
+// "fortuna/seed", "fortuna/entropy", etc. continue to work.  The
+// remaining attributes are appended to the message as key=value
+// pairs.
+//
+// Log levels are mapped to trace priorities as follows:
+// LevelCritical and above to trace.PrioCritical, slog.LevelWarn and
+// above to trace.PrioError, slog.LevelInfo and above to
+// trace.PrioInfo, slog.LevelDebug and above to trace.PrioVerbose,
+// and lower levels, including LevelTrace, to trace.PrioDebug.
+//
+// Records below the given level are discarded.  If level is nil,
+// slog.LevelDebug is used, so that the per-event LevelTrace records
+// are only passed on if they are requested explicitly.
+func NewTraceHandler(level slog.Leveler) slog.Handler {
+	if level == nil {
+		level = slog.LevelDebug
+	}
+	return &traceHandler{path: "fortuna", level: level}
+}
+
+type traceHandler struct {
+	path   string
+	level  slog.Leveler
+	attrs  string // preformatted attributes from WithAttrs()
+	prefix string // group prefix for attribute keys
+}
+
+func (h *traceHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *traceHandler) Handle(_ context.Context, r slog.Record) error {
+	var prio trace.Priority
+	switch {
+	case r.Level >= LevelCritical:
+		prio = trace.PrioCritical
+	case r.Level >= slog.LevelWarn:
+		prio = trace.PrioError
+	case r.Level >= slog.LevelInfo:
+		prio = trace.PrioInfo
+	case r.Level >= slog.LevelDebug:
+		prio = trace.PrioVerbose
+	default:
+		prio = trace.PrioDebug
+	}
+
+	path := h.path
+	b := &strings.Builder{}
+	b.WriteString(r.Message)
+	b.WriteString(h.attrs)
+	r.Attrs(func(a slog.Attr) bool {
+		if p, ok := h.component(a); ok {
+			path = p
+		} else {
+			appendAttr(b, h.prefix, a)
+		}
+		return true
+	})
+	trace.T(path, prio, "%s", b.String())
+	return nil
+}
+
+func (h *traceHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	res := *h
+	b := &strings.Builder{}
+	b.WriteString(h.attrs)
+	for _, a := range attrs {
+		if p, ok := h.component(a); ok {
+			res.path = p
+		} else {
+			appendAttr(b, h.prefix, a)
+		}
+	}
+	res.attrs = b.String()
+	return &res
+}
+
+func (h *traceHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	res := *h
+	res.prefix = h.prefix + name + "."
+	return &res
+}
+
+// component checks whether a is a top-level ComponentKey attribute,
+// and if so returns the corresponding trace path.
+func (h *traceHandler) component(a slog.Attr) (string, bool) {
+	if h.prefix != "" || a.Key != ComponentKey {
+		return "", false
+	}
+	return "fortuna/" + a.Value.String(), true
+}
+
+func appendAttr(b *strings.Builder, prefix string, a slog.Attr) {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		if a.Key != "" {
+			prefix += a.Key + "."
+		}
+		for _, ga := range a.Value.Group() {
+			appendAttr(b, prefix, ga)
+		}
+		return
+	}
+	val := a.Value.String()
+	if val == "" || strings.ContainsAny(val, " \t\n\"=") {
+		val = fmt.Sprintf("%q", val)
+	}
+	b.WriteString(" " + prefix + a.Key + "=" + val)
+}
+
+// logEvent logs an event from src, which is about to be added to the
+// pools.
+func (acc *Accumulator) logEvent(msg string, src *source, seq uint, n int, credit float64) {
+	ctx := context.Background()
+	if !acc.entropyLog.Enabled(ctx, LevelTrace) {
+		return
+	}
+	acc.entropyLog.LogAttrs(ctx, LevelTrace, msg,
+		slog.Uint64(logKeySource, src.ID),
+		slog.Int(logKeyPool, int(seq%uint(len(acc.pool)))),
+		slog.Int(logKeyBytes, n),
+		slog.Float64(logKeyCredit, credit))
+}
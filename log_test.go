@@ -0,0 +1,206 @@
+// log_test.go - unit tests for log.go
+// Copyright (C) 2013  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package fortuna
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/seehuhn/trace"
+)
+
+// logRecorder collects the records written by a slog.JSONHandler.
+type logRecorder struct {
+	mutex   sync.Mutex
+	records []map[string]interface{}
+}
+
+func (rec *logRecorder) Write(p []byte) (int, error) {
+	var r map[string]interface{}
+	err := json.Unmarshal(p, &r)
+	if err != nil {
+		return 0, err
+	}
+	rec.mutex.Lock()
+	rec.records = append(rec.records, r)
+	rec.mutex.Unlock()
+	return len(p), nil
+}
+
+// find returns the first record with the given message.
+func (rec *logRecorder) find(msg string) map[string]interface{} {
+	rec.mutex.Lock()
+	defer rec.mutex.Unlock()
+	for _, r := range rec.records {
+		if r[slog.MessageKey] == msg {
+			return r
+		}
+	}
+	return nil
+}
+
+func TestLogger(t *testing.T) {
+	rec := &logRecorder{}
+	logger := slog.New(slog.NewJSONHandler(rec,
+		&slog.HandlerOptions{Level: LevelTrace}))
+	acc, err := NewAccumulatorWithOptions(
+		WithLogger(logger),
+		WithSinkBufferSize(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer acc.Close()
+
+	if r := rec.find("initial seed set"); r == nil || r[ComponentKey] != "seed" {
+		t.Errorf("wrong initial seed record: %v", r)
+	}
+
+	sink, err := acc.NewSource("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink <- []byte{1, 2, 3}
+	sink <- []byte{4, 5, 6} // the first event has been processed
+	r := rec.find("adding data")
+	if r == nil || r[ComponentKey] != "entropy" ||
+		r[slog.LevelKey] != LevelTrace.String() ||
+		r[logKeySource] != 0.0 || r[logKeyBytes] != 3.0 || r[logKeyCredit] != 3.0 {
+		t.Errorf("wrong entropy record: %v", r)
+	}
+
+	acc.addRandomEvent(0, 0, make([]byte, 32))
+	acc.addRandomEvent(0, 0, make([]byte, 32))
+	acc.RandomData(1)
+	r = rec.find("reseeding from pools")
+	if r == nil || r[ComponentKey] != "seed" || r[logKeyReseed] != 1.0 {
+		t.Errorf("wrong reseed record: %v", r)
+	}
+	if r := rec.find("seed updated"); r == nil || r[ComponentKey] != "generator" {
+		t.Errorf("wrong generator record: %v", r)
+	}
+}
+
+func TestSeedStoreLogger(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	rec := &logRecorder{}
+	logger := slog.New(slog.NewJSONHandler(rec, nil))
+
+	store := NewDirSeedStore(tempDir, SeedFileStrict)
+	acc, err := NewAccumulatorWithOptions(
+		WithSeedStore(store),
+		WithLogger(logger))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer acc.Close()
+
+	r := rec.find("new seed data written")
+	if r == nil || r[ComponentKey] != "seed" ||
+		r[logKeyPath] != filepath.Join(tempDir, "seed-00") {
+		t.Errorf("wrong seed store record: %v", r)
+	}
+}
+
+func TestGeneratorLogger(t *testing.T) {
+	accRec := &logRecorder{}
+	genRec := &logRecorder{}
+	acc, err := NewAccumulatorWithOptions(
+		WithLogger(slog.New(slog.NewJSONHandler(accRec, nil))),
+		WithGeneratorOptions(
+			WithGeneratorLogger(slog.New(slog.NewJSONHandler(genRec, nil)))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	acc.Close()
+
+	if genRec.find("initial seed set") == nil {
+		t.Error("generator logger not used")
+	}
+	if accRec.find("initial seed set") != nil {
+		t.Error("generator logs to the Accumulator logger")
+	}
+
+	_, err = NewAccumulatorWithOptions(WithLogger(nil))
+	if !errors.Is(err, ErrInvalidOption) {
+		t.Errorf("nil logger: wrong error %v", err)
+	}
+}
+
+func TestTraceHandler(t *testing.T) {
+	type message struct {
+		path string
+		prio trace.Priority
+		msg  string
+	}
+	var mutex sync.Mutex
+	var messages []message
+	listener := func(_ time.Time, path string, prio trace.Priority, msg string) {
+		mutex.Lock()
+		messages = append(messages, message{path, prio, msg})
+		mutex.Unlock()
+	}
+	h := trace.Register(listener, "fortuna/test", trace.PrioAll)
+	defer trace.Unregister(h)
+
+	logger := slog.New(NewTraceHandler(nil)).With(ComponentKey, "test", "a", 1)
+	logger.WithGroup("g").Warn("hello", "b", "x y")
+	logger.Log(context.Background(), LevelCritical, "critical")
+	logger.Debug("debug")
+	logger.Log(context.Background(), LevelTrace, "discarded")
+	if logger.Enabled(context.Background(), LevelTrace) {
+		t.Error("LevelTrace enabled by default")
+	}
+
+	logger = slog.New(NewTraceHandler(LevelTrace)).With(ComponentKey, "test")
+	logger.Log(context.Background(), LevelTrace, "trace")
+
+	expected := []message{
+		{"fortuna/test", trace.PrioError, `hello a=1 g.b="x y"`},
+		{"fortuna/test", trace.PrioCritical, "critical a=1"},
+		{"fortuna/test", trace.PrioVerbose, "debug a=1"},
+		{"fortuna/test", trace.PrioDebug, "trace"},
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	var got []message
+	for _, m := range messages {
+		if m.path == "fortuna/test" {
+			got = append(got, m)
+		}
+	}
+	if len(got) != len(expected) {
+		t.Fatalf("wrong messages: %v", got)
+	}
+	for i, m := range got {
+		if m != expected[i] {
+			t.Errorf("wrong message %d: %v != %v", i, m, expected[i])
+		}
+	}
+}
@@ -0,0 +1,322 @@
+// metrics.go - export the state of an Accumulator as OpenMetrics
+// Copyright (C) 2013  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package metrics exports the state of a fortuna.Accumulator in the
+// OpenMetrics text format, so that it can be collected by Prometheus
+// and compatible monitoring systems.  The package does not depend on
+// the Prometheus client library.
+//
+// A typical use is as follows:
+//
+//	rng, err := fortuna.NewRNG(seedFileName)
+//	...
+//	http.Handle("/metrics", metrics.NewHandler(rng))
+//
+// All metric names start with "fortuna_".  The exported values are
+// taken from the Stats() and Sources() methods of the Accumulator and
+// never contain secret material.  Metrics per entropy source are only
+// exported for open sources, so that the number of time series stays
+// bounded; closed sources are included in the "fortuna_closed_source_*"
+// totals.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/seehuhn/fortuna"
+)
+
+// ContentType is the content type of the OpenMetrics text format.
+const ContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+
+// Handler is an http.Handler which serves the metrics for an
+// Accumulator.
+type Handler struct {
+	acc    *fortuna.Accumulator
+	labels []label
+}
+
+// Option is the type of the optional arguments of NewHandler().
+type Option func(h *Handler)
+
+// WithLabel adds a constant label to all exported metrics.  This can
+// be used to distinguish several Accumulators in the same process.
+// The name must be a valid label name, must not be used for more than
+// one label, and must be different from the names "pool", "source"
+// and "source_id" used by the Handler.
+func WithLabel(name, value string) Option {
+	return func(h *Handler) {
+		h.labels = append(h.labels, label{name, value})
+	}
+}
+
+// NewHandler returns a new Handler for the given Accumulator.  If a
+// label name given to WithLabel() is invalid, NewHandler panics.
+func NewHandler(acc *fortuna.Accumulator, opts ...Option) *Handler {
+	h := &Handler{
+		acc: acc,
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	seen := make(map[string]bool)
+	for _, l := range h.labels {
+		switch {
+		case !validLabelName(l.name):
+			panic("metrics: invalid label name " + strconv.Quote(l.name))
+		case reservedLabels[l.name]:
+			panic("metrics: reserved label name " + strconv.Quote(l.name))
+		case seen[l.name]:
+			panic("metrics: duplicate label name " + strconv.Quote(l.name))
+		}
+		seen[l.name] = true
+	}
+	return h
+}
+
+// ServeHTTP writes the current metrics in the OpenMetrics text format.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", ContentType)
+	if r.Method == http.MethodHead {
+		return
+	}
+	h.WriteTo(w)
+}
+
+// WriteTo writes the current metrics in the OpenMetrics text format
+// to w.  This implements the io.WriterTo interface.
+func (h *Handler) WriteTo(w io.Writer) (int64, error) {
+	stats := h.acc.Stats()
+	sources := h.acc.Sources()
+
+	e := &encoder{
+		w:      bufio.NewWriter(w),
+		labels: h.labels,
+	}
+
+	e.family("fortuna_seeded", "gauge",
+		"Whether the generator has been seeded from the pools or a seed file.")
+	e.sample("fortuna_seeded", nil, boolValue(stats.Seeded))
+
+	e.family("fortuna_reseeds", "counter",
+		"Number of reseeds of the generator from the entropy pools.")
+	e.sample("fortuna_reseeds_total", nil, float64(stats.ReseedCount))
+
+	e.family("fortuna_last_reseed_timestamp_seconds", "gauge",
+		"Time of the last reseed from the entropy pools.")
+	e.sample("fortuna_last_reseed_timestamp_seconds", nil, timeValue(stats.LastReseed))
+
+	e.family("fortuna_output_bytes", "counter",
+		"Number of bytes of random output served.")
+	e.sample("fortuna_output_bytes_total", nil, float64(stats.TotalOutputBytes))
+
+	e.family("fortuna_output_since_reseed_bytes", "gauge",
+		"Number of bytes of random output since the last reseed.")
+	e.sample("fortuna_output_since_reseed_bytes", nil, float64(stats.OutputBytes))
+
+	e.family("fortuna_pool_bytes", "gauge",
+		"Amount of event data added to each pool since it was last used.")
+	for i, pool := range stats.Pools {
+		e.sample("fortuna_pool_bytes", poolLabel(i), float64(pool.Bytes))
+	}
+	e.family("fortuna_pool_entropy_bits", "gauge",
+		"Entropy credited to each pool since it was last used.")
+	for i, pool := range stats.Pools {
+		e.sample("fortuna_pool_entropy_bits", poolLabel(i), pool.Entropy)
+	}
+
+	e.family("fortuna_sources_active", "gauge",
+		"Number of open entropy sources.")
+	e.sample("fortuna_sources_active", nil, float64(stats.ActiveSources))
+
+	e.family("fortuna_source_events", "counter",
+		"Number of events mixed into the pools, per entropy source.")
+	for _, src := range sources {
+		e.sample("fortuna_source_events_total", sourceLabels(src), float64(src.Events))
+	}
+	e.family("fortuna_source_credit_bits", "counter",
+		"Entropy credited to the pools, per entropy source.")
+	for _, src := range sources {
+		e.sample("fortuna_source_credit_bits_total", sourceLabels(src), src.Credit)
+	}
+
+	e.family("fortuna_closed_sources", "counter",
+		"Number of closed entropy sources.")
+	e.sample("fortuna_closed_sources_total", nil, float64(stats.ClosedSources))
+	e.family("fortuna_closed_source_events", "counter",
+		"Number of events mixed into the pools by closed entropy sources.")
+	e.sample("fortuna_closed_source_events_total", nil, float64(stats.ClosedSourceEvents))
+	e.family("fortuna_closed_source_credit_bits", "counter",
+		"Entropy credited to the pools by closed entropy sources.")
+	e.sample("fortuna_closed_source_credit_bits_total", nil, stats.ClosedSourceCredit)
+
+	e.family("fortuna_health_failures", "counter",
+		"Number of health test failures of entropy sources.")
+	e.sample("fortuna_health_failures_total", nil, float64(stats.HealthFailures))
+
+	e.family("fortuna_forks", "counter",
+		"Number of detected forks of the process.")
+	e.sample("fortuna_forks_total", nil, float64(stats.Forks))
+
+	if stats.SeedFile.Enabled {
+		e.family("fortuna_seed_file_writes", "counter",
+			"Write counter of the seed file.")
+		e.sample("fortuna_seed_file_writes_total", nil, float64(stats.SeedFile.Counter))
+
+		e.family("fortuna_seed_file_last_saved_timestamp_seconds", "gauge",
+			"Time of the last successful write of the seed file.")
+		e.sample("fortuna_seed_file_last_saved_timestamp_seconds", nil,
+			timeValue(stats.SeedFile.LastSaved))
+
+		e.family("fortuna_autosave_failures", "counter",
+			"Number of failed automatic writes of the seed file.")
+		e.sample("fortuna_autosave_failures_total", nil, float64(stats.SeedFile.FailedSaves))
+
+		e.family("fortuna_autosave_failing", "gauge",
+			"Whether the last automatic write of the seed file failed.")
+		e.sample("fortuna_autosave_failing", nil, boolValue(stats.SeedFile.LastError != nil))
+	}
+
+	e.write("# EOF\n")
+	if e.err == nil {
+		e.err = e.w.Flush()
+	}
+	return e.n, e.err
+}
+
+type label struct {
+	name, value string
+}
+
+// reservedLabels are the label names used by the Handler itself.
+var reservedLabels = map[string]bool{
+	"pool":      true,
+	"source":    true,
+	"source_id": true,
+}
+
+func poolLabel(i int) []label {
+	return []label{{"pool", strconv.Itoa(i)}}
+}
+
+func sourceLabels(src fortuna.SourceInfo) []label {
+	return []label{
+		{"source_id", strconv.FormatUint(src.ID, 10)},
+		{"source", src.Name},
+	}
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func timeValue(t time.Time) float64 {
+	if t.IsZero() {
+		return 0
+	}
+	return float64(t.UnixNano()) / 1e9
+}
+
+func validLabelName(name string) bool {
+	if name == "" || strings.HasPrefix(name, "__") {
+		return false
+	}
+	for i, c := range name {
+		switch {
+		case c == '_', c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+		case c >= '0' && c <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// encoder writes metrics in the OpenMetrics text format.  After the
+// first error, all further output is discarded.
+type encoder struct {
+	w      *bufio.Writer
+	labels []label
+	n      int64
+	err    error
+}
+
+func (e *encoder) write(s string) {
+	if e.err != nil {
+		return
+	}
+	n, err := e.w.WriteString(s)
+	e.n += int64(n)
+	e.err = err
+}
+
+func (e *encoder) family(name, typ, help string) {
+	e.write("# TYPE " + name + " " + typ + "\n")
+	e.write("# HELP " + name + " " + escape(help, false) + "\n")
+}
+
+func (e *encoder) sample(name string, labels []label, value float64) {
+	var b strings.Builder
+	b.WriteString(name)
+	all := append(append([]label{}, e.labels...), labels...)
+	if len(all) > 0 {
+		b.WriteByte('{')
+		for i, l := range all {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			fmt.Fprintf(&b, "%s=\"%s\"", l.name, escape(l.value, true))
+		}
+		b.WriteByte('}')
+	}
+	b.WriteByte(' ')
+	b.WriteString(strconv.FormatFloat(value, 'g', -1, 64))
+	b.WriteByte('\n')
+	e.write(b.String())
+}
+
+// escape escapes backslashes and line feeds, and, if quote is true,
+// double quotes.
+func escape(s string, quote bool) string {
+	var b strings.Builder
+	for _, c := range s {
+		switch {
+		case c == '\\':
+			b.WriteString(`\\`)
+		case c == '\n':
+			b.WriteString(`\n`)
+		case c == '"' && quote:
+			b.WriteString(`\"`)
+		default:
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
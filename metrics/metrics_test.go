@@ -0,0 +1,174 @@
+// metrics_test.go - unit tests for metrics.go
+// Copyright (C) 2013  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package metrics
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/seehuhn/fortuna"
+)
+
+func TestHandler(t *testing.T) {
+	acc, err := fortuna.NewAccumulatorWithOptions(
+		fortuna.WithPools(4),
+		fortuna.WithSeedStore(fortuna.NewMemorySeedStore()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer acc.Close()
+
+	sink, err := acc.NewSource("test \"source\"\n", fortuna.WithEntropyCredit(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink <- []byte{1, 2, 3, 4}
+	for i := 0; ; i++ {
+		if acc.Sources()[0].Events > 0 {
+			break
+		}
+		if i > 1000 {
+			t.Fatal("event not processed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	acc.RandomData(100)
+
+	server := httptest.NewServer(NewHandler(acc, WithLabel("instance", "a")))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("wrong status %d", resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != ContentType {
+		t.Errorf("wrong content type %q", ct)
+	}
+
+	text := string(body)
+	if !strings.HasSuffix(text, "\n# EOF\n") {
+		t.Error("output does not end with # EOF")
+	}
+	for _, line := range []string{
+		"# TYPE fortuna_reseeds counter",
+		`fortuna_seeded{instance="a"} 0`,
+		`fortuna_reseeds_total{instance="a"} 0`,
+		`fortuna_output_bytes_total{instance="a"} 100`,
+		`fortuna_pool_bytes{instance="a",pool="3"} 0`,
+		`fortuna_sources_active{instance="a"} 1`,
+		`fortuna_source_events_total{instance="a",source_id="0",source="test \"source\"\n"} 1`,
+		`fortuna_source_credit_bits_total{instance="a",source_id="0",source="test \"source\"\n"} 4`,
+		`fortuna_health_failures_total{instance="a"} 0`,
+		`fortuna_autosave_failures_total{instance="a"} 0`,
+		`fortuna_autosave_failing{instance="a"} 0`,
+	} {
+		if !strings.Contains(text, "\n"+line+"\n") {
+			t.Errorf("missing line %q", line)
+		}
+	}
+
+	// Closed sources are only counted in the totals.
+	close(sink)
+	for i := 0; len(acc.Sources()) > 0; i++ {
+		if i > 1000 {
+			t.Fatal("source not closed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	buf := &bytes.Buffer{}
+	NewHandler(acc).WriteTo(buf)
+	text = buf.String()
+	if strings.Contains(text, "source_id=") {
+		t.Error("closed source exported")
+	}
+	for _, line := range []string{
+		"fortuna_sources_active 0",
+		"fortuna_closed_sources_total 1",
+		"fortuna_closed_source_events_total 1",
+		"fortuna_closed_source_credit_bits_total 4",
+	} {
+		if !strings.Contains(text, "\n"+line+"\n") {
+			t.Errorf("missing line %q", line)
+		}
+	}
+
+	resp, err = http.Post(server.URL, "text/plain", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("POST: wrong status %d", resp.StatusCode)
+	}
+}
+
+func TestWithoutSeedFile(t *testing.T) {
+	acc, err := fortuna.NewAccumulatorWithOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer acc.Close()
+
+	buf := &bytes.Buffer{}
+	n, err := NewHandler(acc).WriteTo(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(buf.Len()) {
+		t.Errorf("wrong byte count %d != %d", n, buf.Len())
+	}
+	text := buf.String()
+	if strings.Contains(text, "autosave") {
+		t.Error("seed file metrics without seed file")
+	}
+	if !strings.Contains(text, "\nfortuna_seeded 0\n") {
+		t.Error("missing fortuna_seeded")
+	}
+}
+
+func TestInvalidLabel(t *testing.T) {
+	cases := [][]Option{
+		{WithLabel("0bad", "x")},
+		{WithLabel("source", "x")},
+		{WithLabel("source_id", "x")},
+		{WithLabel("pool", "x")},
+		{WithLabel("instance", "a"), WithLabel("instance", "b")},
+	}
+	for i, opts := range cases {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%d: invalid label not detected", i)
+				}
+			}()
+			NewHandler(nil, opts...)
+		}()
+	}
+}
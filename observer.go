@@ -0,0 +1,183 @@
+// observer.go - notifications about the life cycle of an Accumulator
+// Copyright (C) 2013  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package fortuna
+
+// Observer receives notifications about important events in the life
+// of an Accumulator, for example to feed audit logs or alerting
+// systems.  Observers can be installed using the WithObserver()
+// option.
+//
+// The events never contain secret material: neither the generator key
+// nor the contents of the entropy pools, the seed file or the
+// submitted entropy.  Events are delivered one at a time and in the
+// order in which they occurred, from the goroutine which caused the
+// event or from another goroutine which is delivering events at the
+// same time.  No internal locks of the Accumulator are held while
+// Observe() is called, so that the observer can call methods like
+// Stats().  Observe() should not block, since this delays the
+// delivery of further events.
+type Observer interface {
+	Observe(ev Event)
+}
+
+// ObserverFunc allows to use an ordinary function as an Observer.
+type ObserverFunc func(ev Event)
+
+// Observe calls f(ev).
+func (f ObserverFunc) Observe(ev Event) {
+	f(ev)
+}
+
+// Event is implemented by the event types passed to an Observer:
+// *ReseedEvent, *SeedLoadEvent, *SeedSaveEvent, *SourceEvent,
+// *HealthEvent and *CloseEvent.
+type Event interface {
+	isEvent()
+}
+
+// ReseedEvent reports that the generator was reseeded from the
+// entropy pools.
+type ReseedEvent struct {
+	// Count is the number of reseeds from the entropy pools since the
+	// Accumulator was created, including this one.
+	Count int
+
+	// Pools lists the indices of the pools which were used.
+	Pools []int
+
+	// Bytes is the amount of seed data drained from the pools.
+	Bytes int
+}
+
+// SeedLoadEvent reports that a seed was read from the seed store, or
+// that the seed store could not be read.
+type SeedLoadEvent struct {
+	// Version is the format version of the seed file, or 0 for seed
+	// files in the legacy format.  Counter is the write counter
+	// stored in the file.  Both are zero if the file could not be
+	// decoded.
+	Version uint16
+	Counter uint64
+
+	// Encrypted indicates whether the stored seed was encrypted.
+	Encrypted bool
+
+	// Stale is non-nil if the seed file was restored from a backup
+	// or copied from a different host, see RollbackPolicy.
+	Stale error
+
+	// Err is non-nil if the stored seed was not used.  This includes
+	// errors while reading the seed store, for example because of
+	// insecure file permissions.
+	Err error
+}
+
+// SeedSaveEvent reports an attempt to write a new seed to the seed
+// store.
+type SeedSaveEvent struct {
+	// Counter is the write counter of the new seed file.
+	Counter uint64
+
+	// Err is non-nil if the seed could not be written.
+	Err error
+}
+
+// SourceEvent reports that an entropy source was registered or
+// closed.
+type SourceEvent struct {
+	Source SourceInfo
+	Closed bool
+}
+
+// HealthEvent reports that an entropy source failed a health test.
+type HealthEvent struct {
+	Err *HealthError
+}
+
+// CloseEvent reports that the Accumulator was closed.  Err is the
+// error returned by Close().
+type CloseEvent struct {
+	Err error
+}
+
+func (*ReseedEvent) isEvent()   {}
+func (*SeedLoadEvent) isEvent() {}
+func (*SeedSaveEvent) isEvent() {}
+func (*SourceEvent) isEvent()   {}
+func (*HealthEvent) isEvent()   {}
+func (*CloseEvent) isEvent()    {}
+
+// WithObserver installs an Observer for the Accumulator.  The option
+// can be given more than once, to install several observers.
+func WithObserver(obs Observer) Option {
+	return func(cfg *config) error {
+		if obs == nil {
+			return invalidOption("observer must not be nil")
+		}
+		cfg.observers = append(cfg.observers, obs)
+		return nil
+	}
+}
+
+// notify queues ev for delivery to all observers.  The caller may
+// hold any of the locks of the Accumulator; the event is delivered by
+// the next call to flushEvents().
+func (acc *Accumulator) notify(ev Event) {
+	if len(acc.cfg.observers) == 0 {
+		return
+	}
+	acc.eventMutex.Lock()
+	acc.events = append(acc.events, ev)
+	acc.eventMutex.Unlock()
+}
+
+// flushEvents delivers all queued events to the observers.  If
+// another goroutine is already delivering events, the queued events
+// are left to that goroutine, so that events are never delivered
+// concurrently or out of order.  The caller must not hold any of the
+// other locks of the Accumulator.
+func (acc *Accumulator) flushEvents() {
+	acc.eventMutex.Lock()
+	if acc.delivering {
+		acc.eventMutex.Unlock()
+		return
+	}
+	acc.delivering = true
+	for len(acc.events) > 0 {
+		events := acc.events
+		acc.events = nil
+		acc.eventMutex.Unlock()
+		for _, ev := range events {
+			for _, obs := range acc.cfg.observers {
+				obs.Observe(ev)
+			}
+		}
+		acc.eventMutex.Lock()
+	}
+	acc.delivering = false
+	acc.eventMutex.Unlock()
+}
+
+// sourceEvent returns a SourceEvent for src.  The caller must hold
+// acc.sourceMutex.
+func sourceEvent(src *source) *SourceEvent {
+	info := src.info()
+	return &SourceEvent{
+		Source: info,
+		Closed: !info.Closed.IsZero(),
+	}
+}
@@ -0,0 +1,299 @@
+// observer_test.go - unit tests for observer.go
+// Copyright (C) 2013  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package fortuna
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+type eventRecorder struct {
+	sync.Mutex
+	events []Event
+}
+
+func (rec *eventRecorder) Observe(ev Event) {
+	rec.Lock()
+	rec.events = append(rec.events, ev)
+	rec.Unlock()
+}
+
+func (rec *eventRecorder) count() int {
+	rec.Lock()
+	defer rec.Unlock()
+	return len(rec.events)
+}
+
+func (rec *eventRecorder) take() []Event {
+	rec.Lock()
+	defer rec.Unlock()
+	res := rec.events
+	rec.events = nil
+	return res
+}
+
+func TestObserver(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+	seedFileName := filepath.Join(tempDir, "seed")
+
+	rec := &eventRecorder{}
+	acc, err := NewAccumulatorWithOptions(
+		WithSeedFile(seedFileName),
+		WithObserver(rec),
+		WithSinkBufferSize(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// no seed file yet, so only the new seed is written
+	events := rec.take()
+	if len(events) != 1 {
+		t.Fatalf("wrong events after start-up: %v", events)
+	}
+	if ev, ok := events[0].(*SeedSaveEvent); !ok || ev.Counter != 1 || ev.Err != nil {
+		t.Errorf("wrong save event: %#v", events[0])
+	}
+
+	// The first reseed from the pools is followed by an automatic
+	// save of the seed.
+	acc.addRandomEvent(0, 0, make([]byte, 32))
+	acc.RandomData(1)
+	for i := 0; i < 100 && rec.count() < 2; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	events = rec.take()
+	if len(events) != 2 {
+		t.Fatalf("wrong events after reseed: %v", events)
+	}
+	if ev, ok := events[0].(*ReseedEvent); !ok || ev.Count != 1 ||
+		len(ev.Pools) != 1 || ev.Pools[0] != 0 || ev.Bytes != poolSize {
+		t.Errorf("wrong reseed event: %#v", events[0])
+	}
+	if ev, ok := events[1].(*SeedSaveEvent); !ok || ev.Counter != 2 || ev.Err != nil {
+		t.Errorf("wrong save event: %#v", events[1])
+	}
+
+	sink, err := acc.NewSource("stuck", WithHealthConfig(HealthConfig{
+		RepetitionCutoff: 2,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink <- []byte{1}
+	sink <- []byte{1}
+	sink <- []byte{2}
+	close(sink)
+	acc.Close()
+
+	events = rec.take()
+	var kinds []string
+	for _, ev := range events {
+		switch ev := ev.(type) {
+		case *SourceEvent:
+			if ev.Source.Name != "stuck" {
+				t.Errorf("wrong source: %#v", ev)
+			}
+			if ev.Closed {
+				kinds = append(kinds, "close source")
+			} else {
+				kinds = append(kinds, "new source")
+			}
+		case *HealthEvent:
+			if ev.Err.SourceName != "stuck" || ev.Err.Test != "repetition count" {
+				t.Errorf("wrong health event: %#v", ev.Err)
+			}
+			kinds = append(kinds, "health")
+		case *SeedSaveEvent:
+			if ev.Counter != 3 || ev.Err != nil {
+				t.Errorf("wrong save event: %#v", ev)
+			}
+			kinds = append(kinds, "save")
+		case *CloseEvent:
+			if ev.Err != nil {
+				t.Errorf("wrong close event: %#v", ev)
+			}
+			kinds = append(kinds, "close")
+		default:
+			t.Errorf("unexpected event %#v", ev)
+		}
+	}
+	expected := []string{"new source", "health", "close source", "save", "close"}
+	if len(kinds) != len(expected) {
+		t.Fatalf("wrong events %v, expected %v", kinds, expected)
+	}
+	for i := range kinds {
+		if kinds[i] != expected[i] {
+			t.Errorf("wrong events %v, expected %v", kinds, expected)
+			break
+		}
+	}
+
+	// Reopen, so that the seed is loaded.
+	acc, err = NewAccumulatorWithOptions(
+		WithSeedFile(seedFileName),
+		WithObserver(rec))
+	if err != nil {
+		t.Fatal(err)
+	}
+	acc.Close()
+	events = rec.take()
+	if ev, ok := events[0].(*SeedLoadEvent); !ok || ev.Version != seedFileVersion ||
+		ev.Counter != 3 || ev.Encrypted || ev.Stale != nil || ev.Err != nil {
+		t.Errorf("wrong load event: %#v", events[0])
+	}
+
+	// A corrupted seed file is reported.
+	err = ioutil.WriteFile(seedFileName, make([]byte, 100), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = NewAccumulatorWithOptions(
+		WithSeedFile(seedFileName),
+		WithObserver(rec))
+	if !errors.Is(err, ErrCorruptedSeed) {
+		t.Fatal("corrupted seed file not detected:", err)
+	}
+	events = rec.take()
+	if len(events) != 1 {
+		t.Fatalf("wrong events for corrupted seed file: %v", events)
+	}
+	if ev, ok := events[0].(*SeedLoadEvent); !ok || ev.Version != 0 ||
+		!errors.Is(ev.Err, ErrCorruptedSeed) {
+		t.Errorf("wrong load event: %#v", events[0])
+	}
+
+	// So are errors while reading the seed file.
+	err = ioutil.WriteFile(seedFileName, make([]byte, 1<<20), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chmod(seedFileName, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = NewAccumulatorWithOptions(
+		WithSeedFile(seedFileName),
+		WithObserver(rec))
+	if !errors.Is(err, ErrInsecureSeed) {
+		t.Fatal("insecure seed file not detected:", err)
+	}
+	events = rec.take()
+	if len(events) != 1 {
+		t.Fatalf("wrong events for insecure seed file: %v", events)
+	}
+	if ev, ok := events[0].(*SeedLoadEvent); !ok ||
+		!errors.Is(ev.Err, ErrInsecureSeed) {
+		t.Errorf("wrong load event: %#v", events[0])
+	}
+
+	err = os.Chmod(seedFileName, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = NewAccumulatorWithOptions(
+		WithSeedFile(seedFileName),
+		WithObserver(rec))
+	if !errors.Is(err, ErrCorruptedSeed) {
+		t.Fatal("oversized seed file not detected:", err)
+	}
+	events = rec.take()
+	if len(events) != 1 {
+		t.Fatalf("wrong events for oversized seed file: %v", events)
+	}
+	if ev, ok := events[0].(*SeedLoadEvent); !ok ||
+		!errors.Is(ev.Err, ErrCorruptedSeed) {
+		t.Errorf("wrong load event: %#v", events[0])
+	}
+}
+
+func TestObserverCallsAccumulator(t *testing.T) {
+	var acc *Accumulator
+	var mutex sync.Mutex
+	var reseeds []int
+	obs := ObserverFunc(func(ev Event) {
+		mutex.Lock()
+		a := acc
+		mutex.Unlock()
+		if _, ok := ev.(*CloseEvent); ok || a == nil {
+			return
+		}
+		// This deadlocks if Observe() is called with a lock held.
+		st := a.Stats()
+		if _, ok := ev.(*ReseedEvent); ok {
+			mutex.Lock()
+			reseeds = append(reseeds, st.ReseedCount)
+			mutex.Unlock()
+		}
+		a.Sources()
+	})
+	a, err := NewAccumulatorWithOptions(
+		WithObserver(obs),
+		WithSinkBufferSize(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mutex.Lock()
+	acc = a
+	mutex.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		sink, err := a.NewSource("stuck", WithHealthConfig(HealthConfig{
+			RepetitionCutoff: 2,
+		}))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		sink <- []byte{1}
+		sink <- []byte{1}
+		close(sink)
+
+		a.addRandomEvent(0, 0, make([]byte, 32))
+		a.RandomData(1)
+		a.Close()
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("observer deadlocked")
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(reseeds) != 1 || reseeds[0] != 1 {
+		t.Errorf("wrong reseed counts seen by the observer: %v", reseeds)
+	}
+}
+
+func TestNilObserver(t *testing.T) {
+	_, err := NewAccumulatorWithOptions(WithObserver(nil))
+	if !errors.Is(err, ErrInvalidOption) {
+		t.Error("nil observer not detected:", err)
+	}
+}
@@ -0,0 +1,296 @@
+// options.go - optional arguments for NewAccumulatorWithOptions
+// Copyright (C) 2013  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package fortuna
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// ErrInvalidOption is returned by NewAccumulatorWithOptions() if one
+// of the options has an out-of-range value.  The returned error
+// wraps ErrInvalidOption and describes the offending option.
+var ErrInvalidOption = errors.New("invalid option")
+
+// config collects the settings of an Accumulator.
+type config struct {
+	numPools          int
+	minPoolEntropy    float64
+	maxEventCredit    float64
+	minReseedInterval time.Duration
+	autoSaveInterval  time.Duration
+	autoSaveReseeds   int
+	autoSaveBytes     int64
+	sinkBufferSize    int
+	clock             func() time.Time
+	newSaveTimer      func(d time.Duration) saveTimer
+	seedFileName      string
+	seedFilePolicy    SeedFilePolicy
+	seedStore         SeedStore
+	seedKey           []byte
+	seedPassphrase    []byte
+	rollback          RollbackPolicy
+	forkCanary        bool
+	genOpts           []GeneratorOption
+	logger            *slog.Logger
+
+	healthFailureHandler func(err *HealthError)
+	observers            []Observer
+	unseeded             UnseededPolicy
+	fatalHealthFailures  bool
+}
+
+func defaultConfig() *config {
+	return &config{
+		numPools:          numPools,
+		minPoolEntropy:    8 * minPoolSize,
+		maxEventCredit:    maxEventCredit,
+		minReseedInterval: minReseedInterval,
+		autoSaveInterval:  seedFileUpdateInterval,
+		autoSaveReseeds:   seedFileUpdateReseeds,
+		autoSaveBytes:     seedFileUpdateBytes,
+		sinkBufferSize:    channelBufferSize,
+		clock:             time.Now,
+		newSaveTimer:      newRealSaveTimer,
+		logger:            defaultLogger,
+	}
+}
+
+// Option is the type of the optional arguments of
+// NewAccumulatorWithOptions().
+type Option func(cfg *config) error
+
+func invalidOption(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrInvalidOption, fmt.Sprintf(format, args...))
+}
+
+// WithPools sets the number of entropy pools.  The value must be
+// between 1 and 32; the default is 32.  Using fewer pools reduces
+// memory use, but also limits the time the Accumulator needs to
+// recover from a compromise of the generator state.
+func WithPools(n int) Option {
+	return func(cfg *config) error {
+		if n < 1 || n > numPools {
+			return invalidOption("number of pools must be between 1 and %d, not %d",
+				numPools, n)
+		}
+		cfg.numPools = n
+		return nil
+	}
+}
+
+// WithMinPoolEntropy sets the amount of entropy, in bits, which must
+// be credited to pool 0 before the generator is reseeded.  The credit
+// for each event is determined by the entropy source, see
+// WithEntropyCredit().  The value must be at least 1; the default is
+// 256.
+func WithMinPoolEntropy(bits int) Option {
+	return func(cfg *config) error {
+		if bits < 1 {
+			return invalidOption("minimum pool entropy must be positive, not %d", bits)
+		}
+		cfg.minPoolEntropy = float64(bits)
+		return nil
+	}
+}
+
+// WithMaxEventCredit sets the maximal amount of entropy, in bits,
+// which is credited for a single event from an entropy source,
+// regardless of the credit declared by the source.  In addition, the
+// credit of an event never exceeds half of the value set by
+// WithMinPoolEntropy(), so that no single event can trigger a reseed
+// on its own.  The value must be at least 1; the default is 64.
+func WithMaxEventCredit(bits int) Option {
+	return func(cfg *config) error {
+		if bits < 1 {
+			return invalidOption("maximal event credit must be positive, not %d", bits)
+		}
+		cfg.maxEventCredit = float64(bits)
+		return nil
+	}
+}
+
+// WithMinPoolSize sets the amount of entropy, in bytes, which must be
+// credited to pool 0 before the generator is reseeded.  This is the
+// same as WithMinPoolEntropy(8*n).  The value must be at least 1; the
+// default is 32.
+func WithMinPoolSize(n int) Option {
+	return func(cfg *config) error {
+		if n < 1 {
+			return invalidOption("minimum pool size must be positive, not %d", n)
+		}
+		cfg.minPoolEntropy = float64(8 * n)
+		return nil
+	}
+}
+
+// WithReseedInterval sets the minimum time between two reseeds of
+// the generator.  The value must be positive; the default is 100ms.
+func WithReseedInterval(d time.Duration) Option {
+	return func(cfg *config) error {
+		if d <= 0 {
+			return invalidOption("reseed interval must be positive, not %s", d)
+		}
+		cfg.minReseedInterval = d
+		return nil
+	}
+}
+
+// WithAutoSaveInterval sets the maximal time for which activity of
+// the Accumulator can go unsaved: if random output was generated or
+// the generator was reseeded since the seed file was last written,
+// the seed file is updated after this time.  While the Accumulator is
+// idle, the seed file is not written.  The value must be at least one
+// second; the default is 10 minutes.  See also WithAutoSaveTriggers().
+func WithAutoSaveInterval(d time.Duration) Option {
+	return func(cfg *config) error {
+		if d < time.Second {
+			return invalidOption("autosave interval must be at least 1s, not %s", d)
+		}
+		cfg.autoSaveInterval = d
+		return nil
+	}
+}
+
+// WithSinkBufferSize sets the capacity of the channels returned by
+// NewEntropyDataSink() and NewEntropyTimeStampSink().  The value
+// must be non-negative; the default is 4.
+func WithSinkBufferSize(n int) Option {
+	return func(cfg *config) error {
+		if n < 0 {
+			return invalidOption("sink buffer size must not be negative, not %d", n)
+		}
+		cfg.sinkBufferSize = n
+		return nil
+	}
+}
+
+// WithClock sets the function used by the Accumulator to obtain the
+// current time.  The default is time.Now.  This is mostly useful for
+// testing.
+func WithClock(now func() time.Time) Option {
+	return func(cfg *config) error {
+		if now == nil {
+			return invalidOption("clock must not be nil")
+		}
+		cfg.clock = now
+		return nil
+	}
+}
+
+// WithSeedFile sets the name of the seed file.  See the
+// documentation of NewRNG() for details.  By default no seed file is
+// used.  WithSeedFile(name) is equivalent to
+// WithSeedStore(NewFileSeedStore(name, policy)), where policy is set
+// by WithSeedFilePolicy().
+func WithSeedFile(seedFileName string) Option {
+	return func(cfg *config) error {
+		cfg.seedFileName = seedFileName
+		return nil
+	}
+}
+
+// WithSeedStore sets the SeedStore used to keep seed data between
+// runs of the program.  The Accumulator takes ownership of the store,
+// see the documentation of SeedStore for details.  WithSeedStore
+// cannot be combined with WithSeedFile.
+func WithSeedStore(store SeedStore) Option {
+	return func(cfg *config) error {
+		if store == nil {
+			return invalidOption("seed store must not be nil")
+		}
+		cfg.seedStore = store
+		return nil
+	}
+}
+
+// WithGeneratorOptions sets options for the underlying Generator.
+// For example, WithGeneratorOptions(WithPrimitive(AESCTR{})) makes
+// the Accumulator use AES-256 in counter mode.
+func WithGeneratorOptions(opts ...GeneratorOption) Option {
+	return func(cfg *config) error {
+		cfg.genOpts = append(cfg.genOpts, opts...)
+		return nil
+	}
+}
+
+// UnseededPolicy determines how an Accumulator serves requests for
+// random data before it is seeded (see Accumulator.IsSeeded()).
+type UnseededPolicy int
+
+const (
+	// UnseededAllow serves requests immediately, using output
+	// derived from the initial seed.  This is the default.
+	UnseededAllow UnseededPolicy = iota
+
+	// UnseededBlock makes Read() and RandomData() block until the
+	// Accumulator is seeded, similar to getrandom(2) without flags.
+	UnseededBlock
+
+	// UnseededError makes Read() return ErrNotSeeded until the
+	// Accumulator is seeded, similar to getrandom(2) with the
+	// GRND_NONBLOCK flag.  RandomData() cannot report errors and
+	// blocks instead.
+	UnseededError
+)
+
+// WithUnseededPolicy sets the behaviour of the Accumulator before it
+// is seeded.  Applications which generate long-term keys should use
+// UnseededBlock or UnseededError.
+func WithUnseededPolicy(policy UnseededPolicy) Option {
+	return func(cfg *config) error {
+		if policy < UnseededAllow || policy > UnseededError {
+			return invalidOption("unknown unseeded policy %d", int(policy))
+		}
+		cfg.unseeded = policy
+		return nil
+	}
+}
+
+// SeedFilePolicy determines how an Accumulator reacts to seed files
+// with insecure permissions or ownership.
+type SeedFilePolicy int
+
+const (
+	// SeedFileStrict refuses to use an insecure seed file; the
+	// constructor returns an *InsecureSeedError.  This is the
+	// default.
+	SeedFileStrict SeedFilePolicy = iota
+
+	// SeedFileLenient logs problems with the permissions or
+	// ownership of the seed file and its directory (see WithLogger()), and
+	// uses the seed file anyway.  Since the seed file is replaced by
+	// a new file with mode 0600 on every update, problems with the
+	// file itself are fixed by the first update.  Symbolic links and
+	// special files are rejected even in lenient mode.
+	SeedFileLenient
+)
+
+// WithSeedFilePolicy sets how the Accumulator handles seed files
+// which fail the permission and ownership checks described in the
+// documentation of NewRNG().
+func WithSeedFilePolicy(policy SeedFilePolicy) Option {
+	return func(cfg *config) error {
+		if policy < SeedFileStrict || policy > SeedFileLenient {
+			return invalidOption("unknown seed file policy %d", int(policy))
+		}
+		cfg.seedFilePolicy = policy
+		return nil
+	}
+}
@@ -0,0 +1,102 @@
+// options_test.go - unit tests for options.go
+// Copyright (C) 2013  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package fortuna
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestInvalidOptions(t *testing.T) {
+	invalid := []Option{
+		WithPools(0),
+		WithPools(numPools + 1),
+		WithMinPoolSize(0),
+		WithMinPoolEntropy(0),
+		WithMaxEventCredit(0),
+		WithReseedInterval(0),
+		WithReseedInterval(-time.Second),
+		WithAutoSaveInterval(time.Millisecond),
+		WithSinkBufferSize(-1),
+		WithClock(nil),
+		WithUnseededPolicy(UnseededError + 1),
+		WithSeedFilePolicy(SeedFileLenient + 1),
+		WithSeedStore(nil),
+		WithSeedKey(make([]byte, 16)),
+		WithSeedPassphrase(""),
+	}
+	for i, opt := range invalid {
+		acc, err := NewAccumulatorWithOptions(opt)
+		if !errors.Is(err, ErrInvalidOption) {
+			t.Errorf("%d: invalid option not detected: %v", i, err)
+		}
+		if acc != nil {
+			t.Errorf("%d: Accumulator returned despite invalid option", i)
+		}
+	}
+}
+
+func TestOptions(t *testing.T) {
+	now := time.Unix(1000000000, 0)
+	clock := func() time.Time { return now }
+
+	acc, err := NewAccumulatorWithOptions(
+		WithPools(4),
+		WithMinPoolSize(8),
+		WithReseedInterval(time.Hour),
+		WithSinkBufferSize(0),
+		WithClock(clock),
+		WithGeneratorOptions(WithPrimitive(ChaCha20{})),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer acc.Close()
+
+	if len(acc.pool) != 4 {
+		t.Error("wrong number of pools")
+	}
+	if _, ok := acc.gen.prim.(ChaCha20); !ok {
+		t.Error("generator options not applied")
+	}
+
+	// 4 bytes are not enough to trigger a reseed, 8 bytes are
+	acc.addRandomEvent(0, 0, []byte{1, 2})
+	acc.RandomData(1)
+	if acc.reseedCount != 0 {
+		t.Error("reseeded before pool 0 was full")
+	}
+	acc.addRandomEvent(0, 4, []byte{3, 4})
+	acc.RandomData(1)
+	if acc.reseedCount != 1 {
+		t.Error("no reseed after pool 0 was full")
+	}
+
+	// the reseed interval is measured using the given clock
+	acc.addRandomEvent(0, 0, make([]byte, 8))
+	now = now.Add(30 * time.Minute)
+	acc.RandomData(1)
+	if acc.reseedCount != 1 {
+		t.Error("reseed interval not respected")
+	}
+	now = now.Add(31 * time.Minute)
+	acc.RandomData(1)
+	if acc.reseedCount != 2 {
+		t.Error("no reseed after reseed interval")
+	}
+}
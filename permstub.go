@@ -0,0 +1,30 @@
+// +build !darwin,!freebsd,!linux,!netbsd,!openbsd
+
+package fortuna
+
+import (
+	"os"
+)
+
+// openNoFollow is not available on this system.
+const openNoFollow = 0
+
+// insecureFile is a dummy function which always returns the empty
+// string on this system.
+//
+// On Unix systems, insecureFile() checks that the seed file is not
+// accessible by group and others and that it is owned by the
+// effective user ID of the process.
+func insecureFile(fi os.FileInfo) string {
+	return ""
+}
+
+// insecureDir is a dummy function which always returns the empty
+// string on this system.
+//
+// On Unix systems, insecureDir() checks that the directory containing
+// the seed file cannot be written by group and others, and that it is
+// owned by the effective user ID of the process or by root.
+func insecureDir(fi os.FileInfo) string {
+	return ""
+}
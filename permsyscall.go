@@ -0,0 +1,50 @@
+// +build darwin freebsd linux netbsd openbsd
+
+package fortuna
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// openNoFollow makes os.OpenFile() fail if the last component of the
+// file name is a symbolic link.
+const openNoFollow = syscall.O_NOFOLLOW
+
+// insecureFile checks the permissions and ownership of the seed file
+// described by fi.  If the file is insecure, a description of the
+// problem is returned, otherwise the empty string is returned.
+func insecureFile(fi os.FileInfo) string {
+	if perm := fi.Mode().Perm(); perm&0077 != 0 {
+		return fmt.Sprintf("mode %04o allows access by group or others", perm)
+	}
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		euid := os.Geteuid()
+		if int(st.Uid) != euid {
+			return fmt.Sprintf("owned by uid %d instead of %d", st.Uid, euid)
+		}
+	}
+	return ""
+}
+
+// insecureDir checks whether users other than the owner can replace
+// files in the directory described by fi.  If this is the case, a
+// description of the problem is returned, otherwise the empty string
+// is returned.  The directory must be owned by the effective user ID
+// of the process or by root.  Directories with the sticky bit set are
+// accepted even if they are writable by others.
+func insecureDir(fi os.FileInfo) string {
+	perm := fi.Mode().Perm()
+	if perm&0022 != 0 && fi.Mode()&os.ModeSticky == 0 {
+		return fmt.Sprintf("directory mode %04o allows writing by group or others", perm)
+	}
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		euid := os.Geteuid()
+		if int(st.Uid) != euid && st.Uid != 0 {
+			return fmt.Sprintf("directory owned by uid %d instead of %d or root",
+				st.Uid, euid)
+		}
+	}
+	return ""
+}
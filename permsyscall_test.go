@@ -0,0 +1,177 @@
+// +build darwin freebsd linux netbsd openbsd
+
+package fortuna
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestInsecureSeedFile(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+	seedFileName := filepath.Join(tempDir, "seed")
+	err = ioutil.WriteFile(seedFileName, testSeedFileData().encode(), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectInsecure := func(what, detail string) {
+		t.Helper()
+		rng, err := NewRNG(seedFileName)
+		if rng != nil {
+			rng.Close()
+		}
+		var insecure *InsecureSeedError
+		if !errors.As(err, &insecure) || !errors.Is(err, ErrInsecureSeed) {
+			t.Errorf("%s not detected: %v", what, err)
+		} else if !strings.Contains(insecure.Reason, detail) {
+			t.Errorf("%s: unexpected reason %q", what, insecure.Reason)
+		}
+	}
+
+	for _, mode := range []os.FileMode{0640, 0604, 0620, 0602} {
+		os.Chmod(seedFileName, mode)
+		expectInsecure("mode "+mode.String(), "mode")
+	}
+	os.Chmod(seedFileName, 0600)
+
+	os.Chmod(tempDir, 0777)
+	expectInsecure("writable directory", "directory")
+	os.Chmod(tempDir, 0777|os.ModeSticky)
+	rng, err := NewRNG(seedFileName)
+	if err != nil {
+		t.Errorf("sticky directory rejected: %v", err)
+	} else {
+		rng.Close()
+	}
+	os.Chmod(tempDir, 0700)
+
+	target := filepath.Join(tempDir, "target")
+	os.Rename(seedFileName, target)
+	os.Symlink(target, seedFileName)
+	expectInsecure("symbolic link", "symbolic link")
+	os.Remove(seedFileName)
+	os.Rename(target, seedFileName)
+
+	if os.Geteuid() == 0 {
+		os.Chown(seedFileName, 1, 1)
+		expectInsecure("foreign owner", "owned by")
+		os.Chown(seedFileName, 0, 0)
+
+		os.Chown(tempDir, 1, 1)
+		expectInsecure("foreign directory owner", "directory owned by")
+		os.Chown(tempDir, 0, 0)
+	}
+
+	os.Remove(seedFileName)
+	err = syscall.Mkfifo(seedFileName, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectInsecure("FIFO", "regular file")
+}
+
+func TestLenientSeedFile(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+	seedFileName := filepath.Join(tempDir, "seed")
+	err = ioutil.WriteFile(seedFileName, testSeedFileData().encode(), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Chmod(seedFileName, 0644)
+
+	rng, err := NewAccumulatorWithOptions(WithSeedFile(seedFileName),
+		WithSeedFilePolicy(SeedFileLenient))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rng.IsSeeded() {
+		t.Error("seed file not used in lenient mode")
+	}
+	rng.Close()
+
+	fi, err := os.Stat(seedFileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Errorf("permissions not fixed: %s", fi.Mode())
+	}
+
+	target := filepath.Join(tempDir, "target")
+	os.Rename(seedFileName, target)
+	os.Symlink(target, seedFileName)
+	rng, err = NewAccumulatorWithOptions(WithSeedFile(seedFileName),
+		WithSeedFilePolicy(SeedFileLenient))
+	if !errors.Is(err, ErrInsecureSeed) {
+		t.Errorf("symbolic link accepted in lenient mode: %v", err)
+	}
+	if rng != nil {
+		rng.Close()
+	}
+}
+
+func TestInsecureLockFile(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+	seedFileName := filepath.Join(tempDir, "seed")
+	lockFileName := seedFileName + seedLockSuffix
+
+	// a symbolic link must not be followed
+	target := filepath.Join(tempDir, "target")
+	err = ioutil.WriteFile(target, []byte("keep"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Symlink(target, lockFileName)
+	rng, err := NewRNG(seedFileName)
+	if !errors.Is(err, ErrInsecureSeed) {
+		t.Errorf("symbolic link not detected: %v", err)
+	}
+	if rng != nil {
+		rng.Close()
+	}
+	if data, _ := ioutil.ReadFile(target); string(data) != "keep" {
+		t.Errorf("symbolic link followed, target now %q", data)
+	}
+	os.Remove(lockFileName)
+
+	// a lock file writable by others could be used to forge the mark
+	err = ioutil.WriteFile(lockFileName, nil, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Chmod(lockFileName, 0666)
+	rng, err = NewRNG(seedFileName)
+	var insecure *InsecureSeedError
+	if !errors.As(err, &insecure) || insecure.Path != lockFileName {
+		t.Errorf("writable lock file not detected: %v", err)
+	}
+	if rng != nil {
+		rng.Close()
+	}
+
+	rng, err = NewAccumulatorWithOptions(WithSeedFile(seedFileName),
+		WithSeedFilePolicy(SeedFileLenient))
+	if err != nil {
+		t.Errorf("lock file rejected in lenient mode: %v", err)
+	} else {
+		rng.Close()
+	}
+}
@@ -0,0 +1,236 @@
+// primitive.go - output functions for the Fortuna generator
+// Copyright (C) 2013  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package fortuna
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/binary"
+
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/chacha20"
+)
+
+// Primitive is the cryptographic building block of a Generator.  A
+// Primitive turns a 32 byte key into a stream of pseudo random bytes,
+// and is used to derive a new key from the old key and a seed when
+// the generator is reseeded.
+//
+// The package provides the primitives BLAKE2bXOF (the default),
+// AESCTR and ChaCha20.  A primitive can be selected using the
+// WithPrimitive() option of NewGenerator().
+type Primitive interface {
+	// Name returns a short, human readable name for the primitive.
+	Name() string
+
+	// DeriveKey returns a new 32 byte key which depends on both
+	// oldKey and seed.  Knowledge of the new key must not allow to
+	// reconstruct oldKey.
+	DeriveKey(oldKey, seed []byte) []byte
+
+	// NewStream returns the output stream for the given 32 byte key.
+	NewStream(key []byte) Stream
+}
+
+// Stream is the output stream of a Primitive for one fixed key.
+type Stream interface {
+	// Read fills p with the next len(p) bytes of output.  Read
+	// always fills the complete slice and never returns an error.
+	Read(p []byte) (n int, err error)
+
+	// Wipe overwrites the internal state of the stream.  The stream
+	// must not be used after Wipe has been called.
+	Wipe()
+}
+
+// newXOF allocates a new BLAKE2b XOF with unknown output length.
+func newXOF() blake2b.XOF {
+	xof, _ := blake2b.NewXOF(blake2b.OutputLengthUnknown, nil)
+	return xof
+}
+
+// BLAKE2bXOF is the default Primitive.  The output stream for a key
+// is given by the BLAKE2b extendable output function applied to the
+// key.  New keys are derived by applying BLAKE2b-XOF to the
+// concatenation of old key and seed.
+type BLAKE2bXOF struct{}
+
+// Name implements the Primitive interface.
+func (BLAKE2bXOF) Name() string {
+	return "BLAKE2b-XOF"
+}
+
+// DeriveKey implements the Primitive interface.
+func (BLAKE2bXOF) DeriveKey(oldKey, seed []byte) []byte {
+	xof := newXOF()
+	xof.Write(oldKey)
+	xof.Write(seed)
+	newKey := make([]byte, keySize)
+	xof.Read(newKey)
+	wipeXOF(xof)
+	return newKey
+}
+
+// NewStream implements the Primitive interface.
+func (BLAKE2bXOF) NewStream(key []byte) Stream {
+	xof := newXOF()
+	xof.Write(key)
+	return &xofStream{xof: xof}
+}
+
+type xofStream struct {
+	xof blake2b.XOF
+}
+
+func (s *xofStream) Read(p []byte) (int, error) {
+	return s.xof.Read(p)
+}
+
+func (s *xofStream) Wipe() {
+	wipeXOF(s.xof)
+}
+
+// AESCTR is the Primitive used in the original description of
+// Fortuna (chapter 10 of [FS03]): the output stream is AES-256 in
+// counter mode, and new keys are derived using SHA-256d applied to
+// the concatenation of old key and seed.
+//
+// In contrast to [FS03], the block counter is restarted at 1 for
+// every new key.  Since keys are never reused, this does not affect
+// security.
+type AESCTR struct{}
+
+// Name implements the Primitive interface.
+func (AESCTR) Name() string {
+	return "AES-256-CTR"
+}
+
+// DeriveKey implements the Primitive interface.
+func (AESCTR) DeriveKey(oldKey, seed []byte) []byte {
+	h := sha256.New()
+	h.Write(oldKey)
+	h.Write(seed)
+	inner := h.Sum(nil)
+	outer := sha256.Sum256(inner)
+	wipe(inner)
+	return outer[:]
+}
+
+// NewStream implements the Primitive interface.
+func (AESCTR) NewStream(key []byte) Stream {
+	var counter [aes.BlockSize]byte
+	counter[0] = 1
+	return newAESStream(key, counter)
+}
+
+// newAESStream returns the AES-256 counter mode stream for key,
+// starting at the given counter block.
+func newAESStream(key []byte, counter [aes.BlockSize]byte) *aesStream {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		panic("fortuna: invalid AES key: " + err.Error())
+	}
+	return &aesStream{block: block, counter: counter}
+}
+
+type aesStream struct {
+	block   cipher.Block
+	counter [aes.BlockSize]byte
+	buf     [aes.BlockSize]byte
+	pos     int
+}
+
+func (s *aesStream) Read(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		if s.pos == 0 {
+			s.block.Encrypt(s.buf[:], s.counter[:])
+			s.increment()
+		}
+		k := copy(p, s.buf[s.pos:])
+		p = p[k:]
+		s.pos = (s.pos + k) % aes.BlockSize
+	}
+	return n, nil
+}
+
+// increment increases the 128 bit little-endian block counter by one.
+func (s *aesStream) increment() {
+	lo := binary.LittleEndian.Uint64(s.counter[:8]) + 1
+	binary.LittleEndian.PutUint64(s.counter[:8], lo)
+	if lo == 0 {
+		hi := binary.LittleEndian.Uint64(s.counter[8:]) + 1
+		binary.LittleEndian.PutUint64(s.counter[8:], hi)
+	}
+}
+
+// Wipe clears the counter and the buffered output.  The expanded AES
+// key schedule is held inside the crypto/aes package and cannot be
+// overwritten; it is only released to the garbage collector.
+func (s *aesStream) Wipe() {
+	wipe(s.counter[:])
+	wipe(s.buf[:])
+	s.block = nil
+	s.pos = 0
+}
+
+// ChaCha20 is a Primitive where the output stream is the ChaCha20
+// key stream for the given key and an all-zero nonce.  New keys are
+// derived in the same way as for BLAKE2bXOF.
+type ChaCha20 struct{}
+
+// Name implements the Primitive interface.
+func (ChaCha20) Name() string {
+	return "ChaCha20"
+}
+
+// DeriveKey implements the Primitive interface.
+func (ChaCha20) DeriveKey(oldKey, seed []byte) []byte {
+	return BLAKE2bXOF{}.DeriveKey(oldKey, seed)
+}
+
+// NewStream implements the Primitive interface.
+func (ChaCha20) NewStream(key []byte) Stream {
+	var nonce [chacha20.NonceSize]byte
+	return newChaChaStream(key, nonce[:], 0)
+}
+
+// newChaChaStream returns the ChaCha20 key stream for key and nonce,
+// starting at the given block counter.
+func newChaChaStream(key, nonce []byte, counter uint32) *chachaStream {
+	c, err := chacha20.NewUnauthenticatedCipher(key, nonce)
+	if err != nil {
+		panic("fortuna: invalid ChaCha20 key: " + err.Error())
+	}
+	c.SetCounter(counter)
+	return &chachaStream{c: c}
+}
+
+type chachaStream struct {
+	c *chacha20.Cipher
+}
+
+func (s *chachaStream) Read(p []byte) (int, error) {
+	wipe(p)
+	s.c.XORKeyStream(p, p)
+	return len(p), nil
+}
+
+func (s *chachaStream) Wipe() {
+	*s.c = chacha20.Cipher{}
+}
@@ -0,0 +1,136 @@
+// primitive_test.go - unit tests for primitive.go
+// Copyright (C) 2013  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package fortuna
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/sha256"
+	"testing"
+)
+
+var allPrimitives = []Primitive{BLAKE2bXOF{}, AESCTR{}, ChaCha20{}}
+
+func TestPrimitiveStreams(t *testing.T) {
+	key := make([]byte, keySize)
+	for i := range key {
+		key[i] = byte(i)
+	}
+
+	for _, prim := range allPrimitives {
+		// reading in small pieces must give the same result as
+		// reading everything at once
+		all := make([]byte, 1000)
+		prim.NewStream(key).Read(all)
+		pieces := make([]byte, 0, 1000)
+		s := prim.NewStream(key)
+		for len(pieces) < 1000 {
+			buf := make([]byte, 7)
+			if 1000-len(pieces) < len(buf) {
+				buf = buf[:1000-len(pieces)]
+			}
+			s.Read(buf)
+			pieces = append(pieces, buf...)
+		}
+		if bytes.Compare(all, pieces) != 0 {
+			t.Errorf("%s: output depends on read sizes", prim.Name())
+		}
+		if isZero(all[:32]) {
+			t.Errorf("%s: no output", prim.Name())
+		}
+
+		newKey := prim.DeriveKey(key, []byte{1, 2, 3})
+		if len(newKey) != keySize || bytes.Compare(newKey, key) == 0 {
+			t.Errorf("%s: invalid derived key", prim.Name())
+		}
+	}
+}
+
+func TestAESCTR(t *testing.T) {
+	key := make([]byte, keySize)
+	key[0] = 1
+	out := make([]byte, 3*aes.BlockSize)
+	AESCTR{}.NewStream(key).Read(out)
+
+	block, _ := aes.NewCipher(key)
+	for i := 0; i < 3; i++ {
+		counter := make([]byte, aes.BlockSize)
+		counter[0] = byte(i + 1)
+		expected := make([]byte, aes.BlockSize)
+		block.Encrypt(expected, counter)
+		if bytes.Compare(out[i*aes.BlockSize:(i+1)*aes.BlockSize], expected) != 0 {
+			t.Errorf("wrong output for block %d", i)
+		}
+	}
+
+	inner := sha256.Sum256([]byte{1, 2, 3, 4, 5})
+	outer := sha256.Sum256(inner[:])
+	newKey := AESCTR{}.DeriveKey([]byte{1, 2, 3}, []byte{4, 5})
+	if bytes.Compare(newKey, outer[:]) != 0 {
+		t.Error("DeriveKey is not SHA-256d")
+	}
+}
+
+func TestStreamWipe(t *testing.T) {
+	s := AESCTR{}.NewStream(make([]byte, keySize)).(*aesStream)
+	s.Read(make([]byte, 5))
+	s.Wipe()
+	if !isZero(s.counter[:]) || !isZero(s.buf[:]) {
+		t.Error("AES stream not wiped")
+	}
+
+	key := make([]byte, keySize)
+	key[0] = 1
+	c := ChaCha20{}.NewStream(key).(*chachaStream)
+	c.Wipe()
+	x := make([]byte, 64)
+	c.Read(x)
+	y := make([]byte, 64)
+	ChaCha20{}.NewStream(key).Read(y)
+	if bytes.Compare(x, y) == 0 {
+		t.Error("ChaCha20 stream not wiped")
+	}
+}
+
+func TestGeneratorPrimitives(t *testing.T) {
+	var outputs [][]byte
+	for _, prim := range allPrimitives {
+		gen := NewGenerator(WithPrimitive(prim))
+		gen.Seed(42)
+		x := gen.PseudoRandomData(100)
+		gen.Seed(42)
+		y := gen.PseudoRandomData(100)
+		if bytes.Compare(x, y) != 0 {
+			t.Errorf("%s: .Seed() doesn't determine generator state",
+				prim.Name())
+		}
+
+		oldKey := append([]byte{}, gen.key...)
+		gen.PseudoRandomData(10)
+		if bytes.Compare(gen.key, oldKey) == 0 {
+			t.Errorf("%s: old key not replaced", prim.Name())
+		}
+
+		for _, z := range outputs {
+			if bytes.Compare(x, z) == 0 {
+				t.Errorf("%s: same output as a different primitive",
+					prim.Name())
+			}
+		}
+		outputs = append(outputs, x)
+	}
+}
@@ -0,0 +1,161 @@
+// rollback.go - detection of restored and cloned seed files
+// Copyright (C) 2013  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package fortuna
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrSeedRollback is returned if the seed file was restored from a
+// backup or copied from another host, and the RollbackRefuse policy is
+// in use.
+var ErrSeedRollback = errors.New("seed file restored or cloned")
+
+// hostID identifies the installation and the current boot of the
+// host.  Zero values indicate that the ID is not known.
+type hostID struct {
+	machine [16]byte
+	boot    [16]byte
+}
+
+// currentHostID returns the ID of the running system.  Tests may
+// change this.
+var currentHostID = readHostID
+
+// SeedMark identifies the last seed written to a SeedStore.
+type SeedMark struct {
+	// Counter is the write counter of the seed.
+	Counter uint64
+
+	// Boot is the boot ID of the system which wrote the seed, or all
+	// zeros if the boot ID is not known.
+	Boot [16]byte
+}
+
+// SeedCounter is an optional interface for a SeedStore.  A store
+// implementing SeedCounter records a SeedMark for every seed it
+// stores.  When the seed is loaded, a seed file with a smaller write
+// counter than the mark, or with the same counter but written during
+// a different boot, is detected as stale.
+//
+// This only works if the mark is kept in a place which is not
+// restored together with the seed.  FileSeedStore and DirSeedStore
+// keep the mark in the lock file next to the seed file.  Backups and
+// VM images normally contain both files, so these stores detect seed
+// files which are restored or copied on their own, but not a restore
+// of the complete directory.  To detect such restores, use a custom
+// SeedStore whose SeedCounter keeps the mark elsewhere, for example in
+// a TPM NV counter or on a remote service.
+type SeedCounter interface {
+	// LoadMark returns the mark last passed to StoreMark(), or the
+	// zero SeedMark if no mark has been stored.
+	LoadMark() (SeedMark, error)
+
+	// StoreMark records the mark of the seed which has just been
+	// stored.
+	StoreMark(mark SeedMark) error
+}
+
+// RollbackPolicy determines how an Accumulator reacts to a seed file
+// which has been restored from a backup or was written on a
+// different host.  Using such a seed file may cause several
+// instances of the generator to produce related output until they
+// are reseeded from the entropy pools.
+type RollbackPolicy int
+
+const (
+	// RollbackWarn logs the problem (see WithLogger()) and uses the
+	// seed file anyway.  This is the default.
+	RollbackWarn RollbackPolicy = iota
+
+	// RollbackRefuse makes NewAccumulatorWithOptions() return an
+	// error wrapping ErrSeedRollback.
+	RollbackRefuse
+
+	// RollbackReseed logs the problem and reseeds the generator with
+	// fresh entropy from the operating system before any output is
+	// generated.  The Accumulator is not marked as seeded by the
+	// seed file.
+	RollbackReseed
+)
+
+// WithRollbackPolicy sets how the Accumulator handles seed files
+// which were restored from a backup or copied from a different host.
+// Restored seed files are only detected if the store implements
+// SeedCounter and keeps its mark in a place which is not restored
+// with the seed; see SeedCounter for the limits of FileSeedStore.
+func WithRollbackPolicy(policy RollbackPolicy) Option {
+	return func(cfg *config) error {
+		if policy < RollbackWarn || policy > RollbackReseed {
+			return invalidOption("unknown rollback policy %d", int(policy))
+		}
+		cfg.rollback = policy
+		return nil
+	}
+}
+
+// checkRollback checks whether the seed file sf is stale or was
+// written on a different host.  The argument last is the mark
+// recorded by the SeedCounter of the store, or the zero SeedMark.  If
+// a problem is found, an error wrapping ErrSeedRollback is returned.
+func checkRollback(sf *seedFileData, current hostID, last SeedMark) error {
+	var zero [16]byte
+	if sf.host.machine != zero && current.machine != zero &&
+		sf.host.machine != current.machine {
+		return fmt.Errorf("%w: seed file was written on host %x, this is host %x",
+			ErrSeedRollback, sf.host.machine, current.machine)
+	}
+	if sf.counter < last.Counter {
+		return fmt.Errorf("%w: seed file has write counter %d, but %d writes happened",
+			ErrSeedRollback, sf.counter, last.Counter)
+	}
+	if sf.counter == last.Counter && sf.host.boot != zero && last.Boot != zero &&
+		sf.host.boot != last.Boot {
+		// Another seed with the same counter was written during a
+		// different boot, so this file has already been used.
+		return fmt.Errorf("%w: seed file was written during boot %x, but write %d happened during boot %x",
+			ErrSeedRollback, sf.host.boot, last.Counter, last.Boot)
+	}
+	return nil
+}
+
+// loadMark returns the mark from the SeedCounter of the seed store,
+// or the zero SeedMark if the store does not implement SeedCounter.
+func (acc *Accumulator) loadMark() (SeedMark, error) {
+	if sc, ok := acc.store.(SeedCounter); ok {
+		return sc.LoadMark()
+	}
+	return SeedMark{}, nil
+}
+
+// storeSeed writes data to the seed store and then records the write
+// counter and the boot ID, if the store implements SeedCounter.  The
+// caller must hold acc.saveMutex.
+func (acc *Accumulator) storeSeed(data []byte, counter uint64) error {
+	err := acc.store.Store(data)
+	if err != nil {
+		return err
+	}
+	if sc, ok := acc.store.(SeedCounter); ok {
+		return sc.StoreMark(SeedMark{
+			Counter: counter,
+			Boot:    acc.seedInfo.host.boot,
+		})
+	}
+	return nil
+}
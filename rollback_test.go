@@ -0,0 +1,220 @@
+// rollback_test.go - unit tests for rollback.go
+// Copyright (C) 2013  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package fortuna
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// restoredStore returns a MemorySeedStore where the seed has been
+// rolled back to an earlier version.
+func restoredStore(t *testing.T) *MemorySeedStore {
+	store := NewMemorySeedStore()
+	var backup []byte
+	for i := 0; i < 2; i++ {
+		acc, err := NewAccumulatorWithOptions(WithSeedStore(store))
+		if err != nil {
+			t.Fatal(err)
+		}
+		acc.Close()
+		if i == 0 {
+			backup, _ = store.Load()
+		}
+	}
+	store.Store(backup)
+	return store
+}
+
+func TestRollbackPolicies(t *testing.T) {
+	acc, err := NewAccumulatorWithOptions(WithSeedStore(restoredStore(t)),
+		WithRollbackPolicy(RollbackRefuse))
+	if !errors.Is(err, ErrSeedRollback) {
+		t.Errorf("restored seed not detected: %v", err)
+	}
+	if acc != nil {
+		acc.Close()
+	}
+
+	acc, err = NewAccumulatorWithOptions(WithSeedStore(restoredStore(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !acc.IsSeeded() {
+		t.Error("restored seed not used with RollbackWarn")
+	}
+	acc.Close()
+
+	acc, err = NewAccumulatorWithOptions(WithSeedStore(restoredStore(t)),
+		WithRollbackPolicy(RollbackReseed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if acc.IsSeeded() {
+		t.Error("restored seed counted as seeding with RollbackReseed")
+	}
+	acc.Close()
+
+	_, err = NewAccumulatorWithOptions(WithRollbackPolicy(RollbackReseed + 1))
+	if !errors.Is(err, ErrInvalidOption) {
+		t.Errorf("invalid policy not detected: %v", err)
+	}
+}
+
+func TestCloneDetection(t *testing.T) {
+	defer func(orig func() hostID) { currentHostID = orig }(currentHostID)
+	host := hostID{machine: [16]byte{1}, boot: [16]byte{2}}
+	currentHostID = func() hostID { return host }
+
+	store := NewMemorySeedStore()
+	acc, err := NewAccumulatorWithOptions(WithSeedStore(store))
+	if err != nil {
+		t.Fatal(err)
+	}
+	acc.Close()
+	if sf := loadSeed(t, store); sf.host != host {
+		t.Errorf("wrong host ID %v recorded", sf.host)
+	}
+
+	// a reboot is fine
+	host.boot[0]++
+	acc, err = NewAccumulatorWithOptions(WithSeedStore(store),
+		WithRollbackPolicy(RollbackRefuse))
+	if err != nil {
+		t.Fatal(err)
+	}
+	acc.Close()
+
+	// a different host is not, even with a plausible counter
+	host.machine[0]++
+	store.StoreMark(SeedMark{})
+	acc, err = NewAccumulatorWithOptions(WithSeedStore(store),
+		WithRollbackPolicy(RollbackRefuse))
+	if !errors.Is(err, ErrSeedRollback) {
+		t.Errorf("cloned seed not detected: %v", err)
+	}
+	if acc != nil {
+		acc.Close()
+	}
+}
+
+func TestSeedFileRollback(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+	seedFileName := filepath.Join(tempDir, "seed")
+	open := func() (*Accumulator, error) {
+		return NewAccumulatorWithOptions(WithSeedFile(seedFileName),
+			WithRollbackPolicy(RollbackRefuse))
+	}
+
+	acc, err := open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	acc.Close()
+	backup, err := ioutil.ReadFile(seedFileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	acc, err = open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	acc.Close()
+
+	err = ioutil.WriteFile(seedFileName, backup, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	acc, err = open()
+	if !errors.Is(err, ErrSeedRollback) {
+		t.Errorf("restored seed file not detected: %v", err)
+	}
+	if acc != nil {
+		acc.Close()
+	}
+
+	// removing the seed file does not reset the counter
+	os.Remove(seedFileName)
+	acc, err = open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	acc.Close()
+	acc, err = open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	acc.Close()
+}
+
+func TestBootCheck(t *testing.T) {
+	defer func(orig func() hostID) { currentHostID = orig }(currentHostID)
+	host := hostID{machine: [16]byte{1}, boot: [16]byte{1}}
+	currentHostID = func() hostID { return host }
+	run := func(store *MemorySeedStore) error {
+		acc, err := NewAccumulatorWithOptions(WithSeedStore(store),
+			WithRollbackPolicy(RollbackRefuse))
+		if err != nil {
+			return err
+		}
+		return acc.Close()
+	}
+	clone := func(store *MemorySeedStore) *MemorySeedStore {
+		res := NewMemorySeedStore()
+		data, _ := store.Load()
+		res.Store(data)
+		mark, _ := store.LoadMark()
+		res.StoreMark(mark)
+		return res
+	}
+
+	a := NewMemorySeedStore()
+	if err := run(a); err != nil {
+		t.Fatal(err)
+	}
+	if mark, _ := a.LoadMark(); mark.Counter == 0 || mark.Boot != host.boot {
+		t.Errorf("wrong mark %v", mark)
+	}
+
+	// two clones of the same image boot and each write seed 2
+	b := clone(a)
+	host.boot[0] = 2
+	if err := run(a); err != nil {
+		t.Fatal(err)
+	}
+	host.boot[0] = 3
+	if err := run(b); err != nil {
+		t.Fatal(err)
+	}
+
+	// the seed of the first clone has the same counter as the
+	// seed of the second clone, but was written during a different
+	// boot
+	data, _ := a.Load()
+	b.Store(data)
+	host.boot[0] = 4
+	if err := run(b); !errors.Is(err, ErrSeedRollback) {
+		t.Errorf("seed from another boot not detected: %v", err)
+	}
+}
@@ -0,0 +1,71 @@
+// secmem.go - memory for secret data
+// Copyright (C) 2013  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package fortuna
+
+import (
+	"log/slog"
+	"runtime"
+)
+
+// lockedBuffer holds secret data, like the generator key and the
+// state of the entropy pools, outside the Go heap.  On Linux the
+// memory is locked into RAM so that it is never written to swap,
+// excluded from core dumps, and surrounded by inaccessible guard
+// pages.  On other systems, or if the memory cannot be allocated, an
+// ordinary byte slice is used instead.
+//
+// The data is wiped and the memory is released when the buffer is
+// garbage collected.  Users should call wipe() as soon as the data is
+// no longer needed.
+type lockedBuffer struct {
+	data []byte
+	mem  []byte // the complete mapping, including guard pages
+}
+
+// newLockedBuffer allocates a buffer for n bytes of secret data.
+// Problems with the allocation are reported to log, which must have
+// the ComponentKey attribute set.
+func newLockedBuffer(n int, log *slog.Logger) *lockedBuffer {
+	buf := &lockedBuffer{}
+	mem, data, err := allocLocked(n, log)
+	if err != nil {
+		log.Info("cannot allocate locked memory, using the Go heap",
+			logKeyBytes, n, logKeyError, err)
+		data = make([]byte, n)
+	}
+	buf.mem = mem
+	buf.data = data
+	runtime.SetFinalizer(buf, (*lockedBuffer).free)
+	return buf
+}
+
+// wipe overwrites the data with zeros.
+func (buf *lockedBuffer) wipe() {
+	wipe(buf.data)
+}
+
+// free wipes the data and releases the memory.  The buffer must not
+// be used after free has been called.
+func (buf *lockedBuffer) free() {
+	buf.wipe()
+	if buf.mem != nil {
+		freeLocked(buf.mem)
+		buf.mem = nil
+	}
+	buf.data = nil
+	runtime.SetFinalizer(buf, nil)
+}
@@ -0,0 +1,101 @@
+// secmem_test.go - unit tests for secmem.go
+// Copyright (C) 2013  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package fortuna
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLockedBuffer(t *testing.T) {
+	buf := newLockedBuffer(100, componentLogger(defaultLogger, "memory"))
+	if len(buf.data) != 100 || cap(buf.data) != 100 {
+		t.Fatal("wrong buffer size", len(buf.data), cap(buf.data))
+	}
+	if !isZero(buf.data) {
+		t.Error("new buffer not zero")
+	}
+	for i := range buf.data {
+		buf.data[i] = byte(i + 1)
+	}
+	data := buf.data
+	buf.wipe()
+	if !isZero(data) {
+		t.Error("buffer not wiped")
+	}
+	buf.free()
+	if buf.data != nil || buf.mem != nil {
+		t.Error("buffer not released")
+	}
+}
+
+func TestCloseWipesSecrets(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+	seedFileName := filepath.Join(tempDir, "seed")
+
+	for _, name := range []string{"", seedFileName} {
+		acc, err := NewRNG(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := uint(0); i < 100; i++ {
+			acc.addRandomEvent(0, i, []byte{1, 2, 3, 4})
+		}
+		acc.RandomData(16)
+
+		// Both slices point into the locked memory.
+		key := acc.gen.key
+		pools := acc.poolMem.data
+		if isZero(key) || isZero(pools) {
+			t.Fatal("no secrets before Close")
+		}
+		acc.Close()
+		if !isZero(key) {
+			t.Error("generator key not wiped by Close")
+		}
+		if !isZero(pools) {
+			t.Error("entropy pools not wiped by Close")
+		}
+	}
+}
+
+func TestReseedWipesSeed(t *testing.T) {
+	acc, err := NewAccumulatorWithOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer acc.Close()
+
+	acc.addRandomEvent(0, 0, make([]byte, 32))
+	acc.addRandomEvent(0, 0, make([]byte, 32))
+	seed := acc.tryReseeding()
+	if isZero(seed) {
+		t.Fatal("no seed from the pools")
+	}
+	acc.genMutex.Lock()
+	acc.reseed(seed)
+	acc.genMutex.Unlock()
+	if !isZero(seed) {
+		t.Error("seed not wiped after reseeding")
+	}
+}
@@ -0,0 +1,54 @@
+// +build linux
+
+package fortuna
+
+import (
+	"log/slog"
+	"syscall"
+)
+
+// madvDontDump is MADV_DONTDUMP from <linux/mman.h>.  The constant is
+// missing from the syscall package.
+const madvDontDump = 16
+
+// allocLocked maps n bytes of memory, surrounded by guard pages.  The
+// returned slice data is the usable part of the mapping mem.
+func allocLocked(n int, log *slog.Logger) (mem, data []byte, err error) {
+	pageSize := syscall.Getpagesize()
+	dataSize := (n + pageSize - 1) / pageSize * pageSize
+	mem, err = syscall.Mmap(-1, 0, dataSize+2*pageSize,
+		syscall.PROT_READ|syscall.PROT_WRITE,
+		syscall.MAP_PRIVATE|syscall.MAP_ANON)
+	if err != nil {
+		return nil, nil, err
+	}
+	inner := mem[pageSize : pageSize+dataSize]
+
+	err = syscall.Mprotect(mem[:pageSize], syscall.PROT_NONE)
+	if err == nil {
+		err = syscall.Mprotect(mem[pageSize+dataSize:], syscall.PROT_NONE)
+	}
+	if err == nil {
+		err = syscall.Madvise(inner, madvDontDump)
+	}
+	if err != nil {
+		syscall.Munmap(mem)
+		return nil, nil, err
+	}
+
+	// Locking fails if RLIMIT_MEMLOCK is exhausted.  The guard pages
+	// and the exclusion from core dumps still apply in this case.
+	lockErr := syscall.Mlock(inner)
+	if lockErr != nil {
+		log.Error("cannot lock memory, secrets may be swapped out",
+			logKeyBytes, n, logKeyError, lockErr)
+	}
+
+	// Place the data at the end of the usable area, so that overruns
+	// hit the guard page.
+	return mem, inner[dataSize-n : dataSize : dataSize], nil
+}
+
+func freeLocked(mem []byte) {
+	syscall.Munmap(mem)
+}
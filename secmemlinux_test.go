@@ -0,0 +1,78 @@
+// +build linux
+
+package fortuna
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"unsafe"
+)
+
+// mappingFlags returns the permissions and the VmFlags of the memory
+// mapping containing addr, as listed in /proc/self/smaps.
+func mappingFlags(t *testing.T, addr uintptr) (perms string, flags []string) {
+	f, err := os.Open("/proc/self/smaps")
+	if err != nil {
+		t.Skip("cannot read memory mappings:", err)
+	}
+	defer f.Close()
+
+	found := false
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		var start, end uintptr
+		n, _ := fmt.Sscanf(fields[0], "%x-%x", &start, &end)
+		if n == 2 && len(fields) > 1 {
+			found = addr >= start && addr < end
+			if found {
+				perms = fields[1]
+			}
+		} else if found && fields[0] == "VmFlags:" {
+			return perms, fields[1:]
+		}
+	}
+	t.Fatalf("no mapping found for address %x", addr)
+	return "", nil
+}
+
+func TestLockedMemoryMapping(t *testing.T) {
+	buf := newLockedBuffer(keySize, componentLogger(defaultLogger, "memory"))
+	defer buf.free()
+	if buf.mem == nil {
+		t.Skip("locked memory not available")
+	}
+
+	start := uintptr(unsafe.Pointer(&buf.mem[0]))
+	data := uintptr(unsafe.Pointer(&buf.data[0]))
+	end := start + uintptr(len(buf.mem)) - 1
+
+	_, flags := mappingFlags(t, data)
+	hasFlag := func(flag string) bool {
+		for _, f := range flags {
+			if f == flag {
+				return true
+			}
+		}
+		return false
+	}
+	if !hasFlag("dd") {
+		t.Error("locked memory included in core dumps")
+	}
+	if !hasFlag("lo") {
+		t.Log("memory not locked, RLIMIT_MEMLOCK exhausted?")
+	}
+
+	for _, guard := range []uintptr{start, end} {
+		perms, _ := mappingFlags(t, guard)
+		if !strings.HasPrefix(perms, "---") {
+			t.Errorf("guard page at %x is accessible (%s)", guard, perms)
+		}
+	}
+}
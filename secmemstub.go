@@ -0,0 +1,19 @@
+// +build !linux
+
+package fortuna
+
+import (
+	"errors"
+	"log/slog"
+)
+
+// allocLocked is a dummy function which always returns an error on
+// this system.
+//
+// On Linux, allocLocked() maps memory which is locked into RAM,
+// excluded from core dumps, and surrounded by guard pages.
+func allocLocked(n int, log *slog.Logger) (mem, data []byte, err error) {
+	return nil, nil, errors.New("locked memory not supported")
+}
+
+func freeLocked(mem []byte) {}
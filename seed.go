@@ -18,14 +18,18 @@ package fortuna
 
 import (
 	"errors"
+	"fmt"
 	"io"
+	"io/ioutil"
+	"log/slog"
 	"os"
-
-	"github.com/seehuhn/trace"
+	"path/filepath"
+	"time"
 )
 
 const (
-	seedFileSize = 64
+	seedFileSize   = 64
+	seedLockSuffix = ".lock"
 )
 
 var (
@@ -33,81 +37,299 @@ var (
 	ErrInsecureSeed  = errors.New("seed file with insecure permissions")
 )
 
-func doWriteSeed(f *os.File, seed []byte) error {
-	_, err := f.Seek(0, os.SEEK_SET)
+// InsecureSeedError is returned if the seed file, or the directory
+// containing it, could be read or replaced by other users.
+type InsecureSeedError struct {
+	// Path is the name of the offending file or directory.
+	Path string
+
+	// Reason describes the problem.
+	Reason string
+}
+
+func (err *InsecureSeedError) Error() string {
+	return fmt.Sprintf("%s: %q: %s", ErrInsecureSeed, err.Path, err.Reason)
+}
+
+// Unwrap returns ErrInsecureSeed.
+func (err *InsecureSeedError) Unwrap() error {
+	return ErrInsecureSeed
+}
+
+// checkSeedFile verifies that the seed file fileName and the
+// directory containing it are only accessible by the current user.
+// The file must be a regular file and not a symbolic link.  If the
+// file does not exist, only the directory is checked.  If a check
+// fails, an *InsecureSeedError is returned.  If lenient is true,
+// problems with permissions and ownership are only logged to log.
+func checkSeedFile(fileName string, lenient bool, log *slog.Logger) error {
+	var problems []*InsecureSeedError
+
+	dir := filepath.Dir(fileName)
+	di, err := os.Stat(dir)
 	if err != nil {
 		return err
 	}
+	if reason := insecureDir(di); reason != "" {
+		problems = append(problems, &InsecureSeedError{Path: dir, Reason: reason})
+	}
 
-	n, err := f.Write(seed)
-	if err != nil || n != len(seed) {
-		if err == nil {
-			err = &os.PathError{Op: "write", Path: f.Name(), Err: nil}
+	fi, err := os.Lstat(fileName)
+	if err == nil {
+		switch {
+		case fi.Mode()&os.ModeSymlink != 0:
+			return &InsecureSeedError{Path: fileName, Reason: "is a symbolic link"}
+		case !fi.Mode().IsRegular():
+			return &InsecureSeedError{Path: fileName, Reason: "is not a regular file"}
 		}
+		if reason := insecureFile(fi); reason != "" {
+			problems = append(problems, &InsecureSeedError{Path: fileName, Reason: reason})
+		}
+	} else if !os.IsNotExist(err) {
 		return err
 	}
 
-	err = f.Sync()
-	if err != nil {
-		return err
+	if len(problems) == 0 {
+		return nil
+	} else if !lenient {
+		return problems[0]
+	}
+	for _, problem := range problems {
+		// The seed file is replaced by a new file with mode 0600 when
+		// it is updated, which fixes problems with the file itself.
+		log.Error("insecure seed file", logKeyPath, problem.Path,
+			logKeyReason, problem.Reason)
 	}
-
-	trace.T("fortuna/seed", trace.PrioInfo,
-		"writing new seed data to %q", f.Name())
 	return nil
 }
 
-// Read and update the seed file.
-//
-// If the seed file is empty, reading the seed file is omitted.  After
-// (potentially) reading the contents of the seed file, new seed data
-// is written to the file.  In case the seed file is corrupted or has
-// insecure file permissions, an error is returned.
-func (acc *Accumulator) updateSeedFile() error {
-	fi, err := acc.seedFile.Stat()
+// doWriteSeed atomically replaces the contents of the seed file
+// fileName with data.  The data is first written to a temporary file
+// in the same directory, which is then renamed to fileName.  This
+// guarantees that after a crash the seed file contains either the
+// old or the new data, but never a mixture of both.
+func doWriteSeed(fileName string, data []byte) error {
+	dir, base := filepath.Split(fileName)
+	if dir == "" {
+		dir = "."
+	}
+	tmp, err := ioutil.TempFile(dir, "."+base+".tmp")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmpName)
+		}
+	}()
+
+	n, err := tmp.Write(data)
+	if err != nil || n != len(data) {
+		if err == nil {
+			err = &os.PathError{Op: "write", Path: tmpName, Err: io.ErrShortWrite}
+		}
+		return err
+	}
+	err = tmp.Sync()
+	if err != nil {
+		return err
+	}
+	err = tmp.Close()
 	if err != nil {
 		return err
 	}
 
-	_, err = acc.seedFile.Seek(0, os.SEEK_SET)
+	err = os.Rename(tmpName, fileName)
 	if err != nil {
 		return err
 	}
+	return syncDir(dir)
+}
+
+// readSeedFile returns the contents of the seed file fileName.  If
+// the file does not exist, nil is returned.  Symbolic links and
+// special files are not followed.
+func readSeedFile(fileName string) ([]byte, error) {
+	f, err := os.OpenFile(fileName, os.O_RDONLY|openNoFollow, 0)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if !fi.Mode().IsRegular() {
+		return nil, &InsecureSeedError{Path: fileName, Reason: "is not a regular file"}
+	}
+	n := fi.Size()
+	if n > seedHeaderSize+maxSeedPayloadSize+seedChecksumSize {
+		return nil, seedFormatError(ErrCorruptedSeed, "invalid length %d", n)
+	}
+	data := make([]byte, n)
+	_, err = io.ReadFull(f, data)
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// Read and update the seed file.
+//
+// If the seed store is empty, reading the seed is omitted.  After
+// (potentially) reading the contents of the seed store, new seed data
+// is written to the store.  In case the stored seed is corrupted or
+// the seed file has insecure file permissions, an error is returned.
+func (acc *Accumulator) updateSeedFile() error {
+	acc.saveMutex.Lock()
+	defer acc.saveMutex.Unlock()
 
 	// To prevent attacks we keep the PRNG locked until the new seed
 	// file is safely written to disk.
 	acc.genMutex.Lock()
 	defer acc.genMutex.Unlock()
 
-	n := fi.Size()
-	if n == seedFileSize {
-		seed := make([]byte, seedFileSize)
-		_, err := io.ReadFull(acc.seedFile, seed)
-		if err != nil || isZero(seed) {
-			trace.T("fortuna/seed", trace.PrioError,
-				"seed file %q is corrupted, not used: %s",
-				acc.seedFile.Name(), err)
-			return ErrCorruptedSeed
+	now := acc.cfg.clock()
+	acc.seedInfo.host = currentHostID()
+	last, err := acc.loadMark()
+	var data []byte
+	if err == nil {
+		data, err = acc.store.Load()
+		if err != nil {
+			acc.notify(&SeedLoadEvent{Err: err})
 		}
-		trace.T("fortuna/seed", trace.PrioInfo,
-			"mixing %q into the seed", acc.seedFile.Name())
-		acc.gen.Reseed(seed)
-	} else if n != 0 {
-		trace.T("fortuna/seed", trace.PrioError,
-			"seed file %q has invalid length %d, aborted",
-			acc.seedFile.Name(), n)
-		return ErrCorruptedSeed
-	}
-
-	seed := acc.randomDataUnlocked(seedFileSize)
-	return doWriteSeed(acc.seedFile, seed)
+	} else {
+		acc.notify(&SeedLoadEvent{Err: err})
+	}
+	if err == nil && len(data) > 0 {
+		var sf *seedFileData
+		var seed []byte
+		sf, err = decodeSeedFile(data)
+		if err == nil {
+			seed, err = acc.openSeed(sf)
+		}
+		var stale error
+		if err == nil {
+			stale = checkRollback(sf, acc.seedInfo.host, last)
+			if stale != nil && acc.cfg.rollback == RollbackRefuse {
+				err = stale
+			}
+		}
+		if err == nil {
+			acc.seedLog.Info("mixing stored seed into the generator",
+				logKeyVersion, sf.version, logKeyCounter, sf.counter)
+			if acc.encrypted() && sf.flags&seedFlagEncrypted == 0 {
+				acc.seedLog.Error("stored seed is not encrypted, encrypting on next write")
+			}
+			acc.gen.Reseed(seed)
+			if stale == nil {
+				acc.markSeeded()
+			} else {
+				acc.seedLog.Error("stale seed file", logKeyError, stale)
+				if acc.cfg.rollback == RollbackReseed {
+					acc.gen.setInitialSeed()
+				} else {
+					acc.markSeeded()
+				}
+			}
+			host := acc.seedInfo.host
+			acc.seedInfo = *sf
+			acc.seedInfo.flags = 0
+			acc.seedInfo.host = host
+			acc.seedInfo.payload = nil
+			wipe(seed)
+		}
+		wipe(data)
+
+		ev := &SeedLoadEvent{Stale: stale, Err: err}
+		if sf != nil {
+			ev.Version = sf.version
+			ev.Counter = sf.counter
+			ev.Encrypted = sf.flags&seedFlagEncrypted != 0
+		}
+		acc.notify(ev)
+	}
+	if err != nil {
+		acc.seedLog.Error("stored seed not used", logKeyError, err)
+		return err
+	}
+	if acc.seedInfo.created.IsZero() {
+		// new seed files and legacy files without a header
+		acc.seedInfo.created = now
+	}
+	if acc.seedInfo.counter < last.Counter {
+		// keep the counter monotonic if the seed file was removed
+		acc.seedInfo.counter = last.Counter
+	}
+
+	data = acc.nextSeedFileData(now)
+	err = acc.storeSeed(data, acc.seedInfo.counter)
+	acc.notify(&SeedSaveEvent{Counter: acc.seedInfo.counter, Err: err})
+	if err != nil {
+		return err
+	}
+	acc.lastSaved = now
+	acc.unsavedReseeds = 0
+	acc.unsavedBytes = 0
+	acc.poolSeedSaved = acc.poolReseeded
+	return nil
 }
 
-// writeSeedFile writes 64 bytes of random data to the Fortuna seed
-// file.  If the seed file cannot be written, a non-nil error is
-// returned.  In this case, the random number generator should not be
-// used until the problem is resolved.
+// nextSeedFileData returns the encoded contents of the next version
+// of the seed file, including seedFileSize bytes of fresh random
+// data.  The caller must hold acc.genMutex.
+func (acc *Accumulator) nextSeedFileData(now time.Time) []byte {
+	acc.seedInfo.version = seedFileVersion
+	acc.seedInfo.counter++
+	acc.seedInfo.updated = now
+	sf := acc.seedInfo
+	sf.payload = acc.randomDataUnlocked(seedFileSize)
+	if acc.encrypted() {
+		acc.sealSeed(&sf)
+	}
+	data := sf.encode()
+	wipe(sf.payload)
+	return data
+}
+
+// writeSeedFile writes 64 bytes of random data to the seed store.
+// If the seed cannot be written, a non-nil error is returned.  In
+// this case, the random number generator should not be used until
+// the problem is resolved.
 func (acc *Accumulator) writeSeedFile() error {
-	seed := acc.RandomData(seedFileSize)
-	return doWriteSeed(acc.seedFile, seed)
+	acc.saveMutex.Lock()
+	defer acc.saveMutex.Unlock()
+	if acc.store == nil {
+		return ErrClosed
+	}
+
+	acc.genMutex.Lock()
+	now := acc.cfg.clock()
+	data := acc.nextSeedFileData(now)
+	counter := acc.seedInfo.counter
+	reseeds := acc.unsavedReseeds
+	bytes := acc.unsavedBytes
+	poolReseeded := acc.poolReseeded
+	acc.genMutex.Unlock()
+
+	err := acc.storeSeed(data, counter)
+	acc.notify(&SeedSaveEvent{Counter: counter, Err: err})
+	if err != nil {
+		return err
+	}
+
+	acc.genMutex.Lock()
+	acc.lastSaved = now
+	acc.unsavedReseeds -= reseeds
+	acc.unsavedBytes -= bytes
+	if poolReseeded {
+		acc.poolSeedSaved = true
+	}
+	acc.genMutex.Unlock()
+	return nil
 }
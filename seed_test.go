@@ -18,6 +18,7 @@ package fortuna
 
 import (
 	"bytes"
+	"errors"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -66,14 +67,22 @@ func TestSeedfile(t *testing.T) {
 	// the following would panic if the seed is not reset
 	rng.RandomData(1)
 	err = rng.Close()
-	if len(before) != seedFileSize || bytes.Compare(before, after) == 0 {
+	if len(before) != seedHeaderSize+seedFileSize+seedChecksumSize ||
+		bytes.Compare(before, after) == 0 {
 		t.Error("seed file not correctly updated")
 	}
+	sf, err := decodeSeedFile(after)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sf.counter != 4 {
+		t.Errorf("wrong write counter %d, expected 4", sf.counter)
+	}
 
 	// check that insecure seed files are detected
 	os.Chmod(seedFileName, os.FileMode(0644))
 	rng, err = NewRNG(seedFileName)
-	if err != ErrInsecureSeed {
+	if !errors.Is(err, ErrInsecureSeed) {
 		t.Error("insecure seed file not detected")
 	}
 	if rng != nil {
@@ -87,10 +96,154 @@ func TestSeedfile(t *testing.T) {
 		t.Error(err)
 	}
 	rng, err = NewRNG(seedFileName)
-	if err != ErrCorruptedSeed {
+	if !errors.Is(err, ErrCorruptedSeed) {
 		t.Error("corrupted seed file not detected:", err)
 	}
 	if rng != nil {
 		rng.Close()
 	}
 }
+
+func TestLegacySeedfile(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+	seedFileName := filepath.Join(tempDir, "seed")
+
+	legacy := make([]byte, seedFileSize)
+	for i := range legacy {
+		legacy[i] = byte(i + 1)
+	}
+	err = ioutil.WriteFile(seedFileName, legacy, os.FileMode(0600))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rng, err := NewRNG(seedFileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rng.IsSeeded() {
+		t.Error("legacy seed file not used")
+	}
+	err = rng.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the file is migrated to the new format
+	data, err := ioutil.ReadFile(seedFileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sf, err := decodeSeedFile(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sf.version != seedFileVersion || sf.counter != 2 {
+		t.Errorf("wrong version %d or counter %d", sf.version, sf.counter)
+	}
+}
+
+func TestSeedfileAtomic(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+	seedFileName := filepath.Join(tempDir, "seed")
+
+	rng, err := NewRNG(seedFileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before, err := os.Stat(seedFileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = rng.writeSeedFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	after, err := os.Stat(seedFileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if os.SameFile(before, after) {
+		t.Error("seed file overwritten in place")
+	}
+
+	// the lock must survive replacing the seed file
+	rng2, err := NewRNG(seedFileName)
+	if err == nil {
+		rng2.Close()
+		t.Error("shared seed file not detected after update")
+	}
+
+	err = rng.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// no temporary files are left behind
+	entries, err := ioutil.ReadDir(tempDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fi := range entries {
+		name := fi.Name()
+		if name != "seed" && name != "seed"+seedLockSuffix {
+			t.Errorf("unexpected file %q", name)
+		}
+	}
+}
+
+func TestSeedfileWriteFailure(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+	seedFileName := filepath.Join(tempDir, "seed")
+
+	original := testSeedFileData().encode()
+	err = ioutil.WriteFile(seedFileName, original, os.FileMode(0600))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a failed update must leave the old seed file intact
+	err = doWriteSeed(filepath.Join(tempDir, "missing", "seed"), []byte("new"))
+	if err == nil {
+		t.Error("write to missing directory succeeded")
+	}
+
+	// a failed rename must not leave the temporary file behind
+	blocker := filepath.Join(tempDir, "blocker")
+	err = os.MkdirAll(filepath.Join(blocker, "x"), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = doWriteSeed(blocker, []byte("new"))
+	if err == nil {
+		t.Error("rename over non-empty directory succeeded")
+	}
+	os.RemoveAll(blocker)
+
+	data, err := ioutil.ReadFile(seedFileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Compare(data, original) != 0 {
+		t.Error("old seed file damaged")
+	}
+	entries, err := ioutil.ReadDir(tempDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("unexpected files left behind: %d", len(entries))
+	}
+}
@@ -0,0 +1,274 @@
+// seedcrypt.go - encryption of the stored seed
+// Copyright (C) 2013  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package fortuna
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// The payload of an encrypted seed file has the following format:
+//
+//	size  contents
+//	   1  key derivation: 0 = key given by the caller, 1 = Argon2id
+//	  25  Argon2id parameters (only present for key derivation 1):
+//	      16 bytes salt, time (uint32), memory in KiB (uint32),
+//	      threads (uint8), all big-endian
+//	  24  nonce
+//	  80  the seed, encrypted with XChaCha20-Poly1305
+//
+// The header of the seed file is used as additional data, so that
+// the timestamps and the write counter are authenticated, too.
+const (
+	seedKDFNone     = 0
+	seedKDFArgon2id = 1
+
+	seedKeySize       = chacha20poly1305.KeySize
+	seedSaltSize      = 16
+	seedKDFParamsSize = seedSaltSize + 9
+	seedNonceSize     = chacha20poly1305.NonceSizeX
+	sealedSeedSize    = seedFileSize + chacha20poly1305.Overhead
+
+	// Limits for the Argon2id parameters read from a seed file.  The
+	// memory limit is four times the default.
+	maxArgon2Time    = 1 << 8
+	maxArgon2Memory  = 1 << 18 // KiB
+	maxArgon2Threads = 16
+)
+
+// ErrSeedKey is returned if an encrypted seed file cannot be
+// decrypted, either because the key or passphrase is wrong, or
+// because no key was given.  Since the checksum of the seed file is
+// verified first, accidental damage is reported as ErrCorruptedSeed
+// instead; deliberate modification of an encrypted seed file is
+// indistinguishable from a wrong key.
+var ErrSeedKey = errors.New("wrong key for encrypted seed file")
+
+// WithSeedKey makes the Accumulator encrypt the stored seed with
+// XChaCha20-Poly1305 under the given 32 byte key.  Existing
+// unencrypted seed files are still read, and are encrypted when the
+// seed is next written.  If an encrypted seed file cannot be
+// decrypted with the key, NewAccumulatorWithOptions() returns an
+// error wrapping ErrSeedKey.
+func WithSeedKey(key []byte) Option {
+	return func(cfg *config) error {
+		if len(key) != seedKeySize {
+			return invalidOption("seed key must be %d bytes, not %d",
+				seedKeySize, len(key))
+		}
+		cfg.seedKey = append([]byte{}, key...)
+		return nil
+	}
+}
+
+// WithSeedPassphrase is like WithSeedKey(), but the key is derived
+// from a passphrase using Argon2id.  Deriving the key takes a
+// noticeable amount of time and 64MiB of memory; this is done once
+// per Accumulator.  WithSeedPassphrase cannot be combined with
+// WithSeedKey.
+//
+// The Argon2id parameters are read from the seed file.  Seed files
+// which ask for more than 256 iterations, 256MiB of memory or 16
+// threads are rejected as corrupted.  Within these limits, an
+// attacker who can modify the seed file can still make the key
+// derivation at start-up take up to 256 passes over 256MiB of
+// memory, delaying NewAccumulatorWithOptions() considerably.
+//
+// The Accumulator wipes its copy of the passphrase after use, but Go
+// strings cannot be overwritten, so the passphrase string itself may
+// remain in memory until it is garbage collected (and possibly
+// longer).  Programs which need to control this should derive a key
+// from the passphrase themselves, wipe the passphrase, and use
+// WithSeedKey().
+func WithSeedPassphrase(passphrase string) Option {
+	return func(cfg *config) error {
+		if passphrase == "" {
+			return invalidOption("seed passphrase must not be empty")
+		}
+		cfg.seedPassphrase = []byte(passphrase)
+		return nil
+	}
+}
+
+// argon2Params holds the parameters for deriving the seed file key
+// from a passphrase.  The defaults follow the second recommendation
+// of RFC 9106.
+type argon2Params struct {
+	time    uint32
+	memory  uint32
+	threads uint8
+}
+
+// defaultArgon2Params are used when a new passphrase-encrypted seed
+// file is written.  Tests may change this to speed things up.
+var defaultArgon2Params = argon2Params{
+	time:    3,
+	memory:  64 * 1024,
+	threads: 4,
+}
+
+// seedKey holds the key used to encrypt the seed file, together with
+// the information needed to derive it from a passphrase.
+type seedKey struct {
+	kdf    byte
+	salt   []byte
+	params argon2Params
+	key    []byte
+}
+
+func (k *seedKey) wipe() {
+	if k != nil {
+		wipe(k.key)
+	}
+}
+
+func deriveSeedKey(passphrase, salt []byte, params argon2Params) *seedKey {
+	return &seedKey{
+		kdf:    seedKDFArgon2id,
+		salt:   salt,
+		params: params,
+		key: argon2.IDKey(passphrase, salt,
+			params.time, params.memory, params.threads, seedKeySize),
+	}
+}
+
+// wipeSeedSecrets overwrites the copies of the key and passphrase
+// kept in cfg.  This is done once the seed file has been read and the
+// key is held in acc.seedKey.  The slices stay non-nil, so that
+// encrypted() is not affected.
+func (cfg *config) wipeSeedSecrets() {
+	wipe(cfg.seedKey)
+	wipe(cfg.seedPassphrase)
+}
+
+// encrypted returns true if the seed file is to be encrypted.
+func (acc *Accumulator) encrypted() bool {
+	return acc.cfg.seedKey != nil || acc.cfg.seedPassphrase != nil
+}
+
+// sealSeed encrypts sf.payload and sets the encryption flag.  The
+// caller must hold acc.genMutex.
+func (acc *Accumulator) sealSeed(sf *seedFileData) {
+	if acc.seedKey == nil {
+		if acc.cfg.seedKey != nil {
+			acc.seedKey = &seedKey{
+				kdf: seedKDFNone,
+				key: append([]byte{}, acc.cfg.seedKey...),
+			}
+		} else {
+			salt := acc.randomDataUnlocked(seedSaltSize)
+			acc.seedKey = deriveSeedKey(acc.cfg.seedPassphrase, salt,
+				defaultArgon2Params)
+		}
+	}
+	k := acc.seedKey
+
+	var prefix []byte
+	prefix = append(prefix, k.kdf)
+	if k.kdf == seedKDFArgon2id {
+		prefix = append(prefix, k.salt...)
+		var buf [9]byte
+		binary.BigEndian.PutUint32(buf[0:], k.params.time)
+		binary.BigEndian.PutUint32(buf[4:], k.params.memory)
+		buf[8] = k.params.threads
+		prefix = append(prefix, buf[:]...)
+	}
+	prefix = append(prefix, acc.randomDataUnlocked(seedNonceSize)...)
+
+	sf.flags |= seedFlagEncrypted
+	header := sf.header(len(prefix) + sealedSeedSize)
+	aead, _ := chacha20poly1305.NewX(k.key)
+	nonce := prefix[len(prefix)-seedNonceSize:]
+	sealed := aead.Seal(prefix, nonce, sf.payload, header)
+	wipe(sf.payload)
+	sf.payload = sealed
+}
+
+// openSeed returns the seed stored in sf, decrypting it if needed.
+// If the seed file is encrypted, the key is remembered for writing
+// the next seed file.  The caller must hold acc.genMutex.
+func (acc *Accumulator) openSeed(sf *seedFileData) ([]byte, error) {
+	if sf.flags&seedFlagEncrypted == 0 {
+		return sf.payload, nil
+	}
+
+	payload := sf.payload
+	if len(payload) < 1 {
+		return nil, seedFormatError(ErrCorruptedSeed, "empty encrypted payload")
+	}
+	kdf := payload[0]
+	payload = payload[1:]
+	var k *seedKey
+	switch kdf {
+	case seedKDFNone:
+		if acc.cfg.seedKey == nil {
+			return nil, seedFormatError(ErrSeedKey,
+				"seed file is encrypted with a key, but none was given")
+		}
+		k = &seedKey{kdf: seedKDFNone, key: append([]byte{}, acc.cfg.seedKey...)}
+	case seedKDFArgon2id:
+		if acc.cfg.seedPassphrase == nil {
+			return nil, seedFormatError(ErrSeedKey,
+				"seed file is encrypted with a passphrase, but none was given")
+		}
+		if len(payload) < seedKDFParamsSize {
+			return nil, seedFormatError(ErrCorruptedSeed, "truncated key derivation parameters")
+		}
+		salt := append([]byte{}, payload[:seedSaltSize]...)
+		params := argon2Params{
+			time:    binary.BigEndian.Uint32(payload[seedSaltSize:]),
+			memory:  binary.BigEndian.Uint32(payload[seedSaltSize+4:]),
+			threads: payload[seedSaltSize+8],
+		}
+		payload = payload[seedKDFParamsSize:]
+		if params.time < 1 || params.time > maxArgon2Time ||
+			params.memory > maxArgon2Memory ||
+			params.threads < 1 || params.threads > maxArgon2Threads {
+			return nil, seedFormatError(ErrCorruptedSeed, "invalid key derivation parameters")
+		}
+		if acc.seedKey != nil && acc.seedKey.kdf == seedKDFArgon2id &&
+			bytes.Equal(acc.seedKey.salt, salt) && acc.seedKey.params == params {
+			k = acc.seedKey
+		} else {
+			k = deriveSeedKey(acc.cfg.seedPassphrase, salt, params)
+		}
+	default:
+		return nil, seedFormatError(ErrSeedVersion, "unknown key derivation %d", kdf)
+	}
+	if len(payload) != seedNonceSize+sealedSeedSize {
+		return nil, seedFormatError(ErrCorruptedSeed, "invalid encrypted payload length")
+	}
+
+	header := sf.header(len(sf.payload))
+	aead, _ := chacha20poly1305.NewX(k.key)
+	seed, err := aead.Open(nil, payload[:seedNonceSize], payload[seedNonceSize:], header)
+	if err != nil {
+		if k != acc.seedKey {
+			k.wipe()
+		}
+		return nil, ErrSeedKey
+	}
+	if k != acc.seedKey {
+		acc.seedKey.wipe()
+		acc.seedKey = k
+	}
+	return seed, nil
+}
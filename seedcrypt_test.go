@@ -0,0 +1,226 @@
+// seedcrypt_test.go - unit tests for seedcrypt.go
+// Copyright (C) 2013  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package fortuna
+
+import (
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+func init() {
+	// keep the tests fast
+	defaultArgon2Params = argon2Params{time: 1, memory: 64, threads: 1}
+}
+
+func testSeedKey(b byte) []byte {
+	key := make([]byte, seedKeySize)
+	for i := range key {
+		key[i] = b
+	}
+	return key
+}
+
+func loadSeed(t *testing.T, store SeedStore) *seedFileData {
+	t.Helper()
+	data, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sf, err := decodeSeedFile(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sf
+}
+
+func testEncryptedSeed(t *testing.T, right, wrong Option) {
+	store := NewMemorySeedStore()
+	acc, err := NewAccumulatorWithOptions(WithSeedStore(store), right)
+	if err != nil {
+		t.Fatal(err)
+	}
+	acc.Close()
+	if sf := loadSeed(t, store); sf.flags&seedFlagEncrypted == 0 {
+		t.Fatal("seed not encrypted")
+	}
+
+	acc, err = NewAccumulatorWithOptions(WithSeedStore(store), right)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !acc.IsSeeded() {
+		t.Error("not seeded from encrypted seed")
+	}
+	acc.Close()
+
+	for _, opt := range []Option{wrong, WithPools(32)} {
+		before, _ := store.Load()
+		acc, err = NewAccumulatorWithOptions(WithSeedStore(store), opt)
+		if !errors.Is(err, ErrSeedKey) || errors.Is(err, ErrCorruptedSeed) {
+			t.Errorf("wrong key not detected: %v", err)
+		}
+		if acc != nil {
+			acc.Close()
+		}
+		after, _ := store.Load()
+		if string(before) != string(after) {
+			t.Error("seed overwritten despite wrong key")
+		}
+	}
+}
+
+func TestSeedKey(t *testing.T) {
+	testEncryptedSeed(t, WithSeedKey(testSeedKey(1)), WithSeedKey(testSeedKey(2)))
+}
+
+func TestSeedPassphrase(t *testing.T) {
+	testEncryptedSeed(t, WithSeedPassphrase("secret"), WithSeedPassphrase("guess"))
+}
+
+func TestSeedKeyMismatch(t *testing.T) {
+	// a passphrase cannot open a seed encrypted with a key, and vice versa
+	testEncryptedSeed(t, WithSeedKey(testSeedKey(1)), WithSeedPassphrase("secret"))
+	testEncryptedSeed(t, WithSeedPassphrase("secret"), WithSeedKey(testSeedKey(1)))
+}
+
+func TestSeedSecretsWiped(t *testing.T) {
+	zero := func(b []byte) bool {
+		for _, x := range b {
+			if x != 0 {
+				return false
+			}
+		}
+		return true
+	}
+	for _, opt := range []Option{
+		WithSeedKey(testSeedKey(5)), WithSeedPassphrase("secret"),
+	} {
+		store := NewMemorySeedStore()
+		acc, err := NewAccumulatorWithOptions(WithSeedStore(store), opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !zero(acc.cfg.seedKey) || !zero(acc.cfg.seedPassphrase) {
+			t.Error("key material not wiped")
+		}
+		if !acc.encrypted() {
+			t.Error("encryption disabled by wiping")
+		}
+		acc.Close()
+
+		acc, err = NewAccumulatorWithOptions(WithSeedStore(store), opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		acc.Close()
+	}
+}
+
+func TestArgon2Limits(t *testing.T) {
+	store := NewMemorySeedStore()
+	pass := WithSeedPassphrase("secret")
+	acc, err := NewAccumulatorWithOptions(WithSeedStore(store), pass)
+	if err != nil {
+		t.Fatal(err)
+	}
+	acc.Close()
+
+	orig, _ := store.Load()
+	params := seedHeaderSize + 1 + seedSaltSize
+	cases := []struct {
+		name   string
+		modify func(data []byte)
+	}{
+		{"memory", func(data []byte) {
+			binary.BigEndian.PutUint32(data[params+4:], maxArgon2Memory+1)
+		}},
+		{"threads", func(data []byte) {
+			data[params+8] = maxArgon2Threads + 1
+		}},
+	}
+	for _, c := range cases {
+		// Ask for too much and fix up the checksum.
+		data := append([]byte{}, orig...)
+		c.modify(data)
+		n := len(data) - seedChecksumSize
+		copy(data[n:], seedChecksum(data[:n]))
+		store.Store(data)
+
+		acc, err = NewAccumulatorWithOptions(WithSeedStore(store), pass)
+		if !errors.Is(err, ErrCorruptedSeed) {
+			t.Errorf("excessive %s parameter not detected: %v", c.name, err)
+		}
+		if acc != nil {
+			acc.Close()
+		}
+	}
+}
+
+func TestSeedEncryptionMigration(t *testing.T) {
+	store := NewMemorySeedStore()
+	acc, err := NewAccumulatorWithOptions(WithSeedStore(store))
+	if err != nil {
+		t.Fatal(err)
+	}
+	acc.Close()
+
+	key := WithSeedKey(testSeedKey(3))
+	acc, err = NewAccumulatorWithOptions(WithSeedStore(store), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !acc.IsSeeded() {
+		t.Error("unencrypted seed not used")
+	}
+	acc.Close()
+	if sf := loadSeed(t, store); sf.flags&seedFlagEncrypted == 0 {
+		t.Error("seed not encrypted after migration")
+	}
+}
+
+func TestSeedHeaderAuthenticated(t *testing.T) {
+	store := NewMemorySeedStore()
+	key := WithSeedKey(testSeedKey(4))
+	acc, err := NewAccumulatorWithOptions(WithSeedStore(store), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	acc.Close()
+
+	// Modify the write counter and fix up the checksum, as an
+	// attacker could do.
+	data, _ := store.Load()
+	binary.BigEndian.PutUint64(data[32:], 1000)
+	n := len(data) - seedChecksumSize
+	copy(data[n:], seedChecksum(data[:n]))
+	store.Store(data)
+
+	acc, err = NewAccumulatorWithOptions(WithSeedStore(store), key)
+	if !errors.Is(err, ErrSeedKey) {
+		t.Errorf("modified header not detected: %v", err)
+	}
+	if acc != nil {
+		acc.Close()
+	}
+
+	_, err = NewAccumulatorWithOptions(WithSeedKey(testSeedKey(1)),
+		WithSeedPassphrase("secret"))
+	if !errors.Is(err, ErrInvalidOption) {
+		t.Errorf("conflicting options not detected: %v", err)
+	}
+}
@@ -0,0 +1,199 @@
+// seedformat.go - the on-disk format of the seed file
+// Copyright (C) 2013  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package fortuna
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// A seed file consists of a fixed size header, a variable length
+// payload and a checksum:
+//
+//	offset  size  contents
+//	     0     8  magic "FORTUNA\n"
+//	     8     2  format version (big-endian)
+//	    10     2  flags (see below)
+//	    12     4  payload length n
+//	    16     8  creation time (Unix nanoseconds)
+//	    24     8  time of last update (Unix nanoseconds)
+//	    32     8  write counter
+//	    40    16  machine ID of the writing host (or zeros)
+//	    56    16  boot ID of the writing host (or zeros)
+//	    72     n  payload (the seed)
+//	  72+n    32  BLAKE2b-256 checksum of bytes 0, ..., 72+n-1
+//
+// The checksum is keyed with a fixed string.  It detects accidental
+// damage like bit-rot or truncation, but does not protect against
+// deliberate modification of the file.
+//
+// If bit 0 of the flags is set, the payload is encrypted, see
+// seedcrypt.go for the format.  The remaining bits are reserved and
+// must be zero.
+//
+// Version 1 of the format is identical, except that the machine and
+// boot IDs are missing and the payload starts at offset 40.  Seed
+// files written by earlier versions of the package consist of
+// exactly seedFileSize bytes of seed data, without header and
+// checksum.  Such files are still accepted when reading.
+const (
+	seedFileMagic      = "FORTUNA\n"
+	seedFileVersion    = 2
+	seedHeaderSize     = 72
+	seedHeaderSizeV1   = 40
+	seedChecksumSize   = blake2b.Size256
+	seedChecksumKey    = "fortuna seed file checksum"
+	maxSeedPayloadSize = 1 << 16
+
+	seedFlagEncrypted = 1 << 0
+)
+
+// ErrSeedVersion is returned if a seed file was written by a newer
+// version of the package and uses an unknown format version.
+var ErrSeedVersion = errors.New("unsupported seed file version")
+
+// seedFileData holds the decoded contents of a seed file.
+type seedFileData struct {
+	version uint16
+	flags   uint16
+	created time.Time
+	updated time.Time
+	counter uint64
+	host    hostID
+	payload []byte
+}
+
+// headerSize returns the size of the seed file header for the given
+// format version.
+func headerSize(version uint16) int {
+	if version == 1 {
+		return seedHeaderSizeV1
+	}
+	return seedHeaderSize
+}
+
+func seedFormatError(err error, format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", err, fmt.Sprintf(format, args...))
+}
+
+func seedChecksum(data []byte) []byte {
+	h, _ := blake2b.New256([]byte(seedChecksumKey))
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// header returns the header of the seed file, for a payload of n
+// bytes.  Legacy seed files are converted to the current version.
+func (sf *seedFileData) header(n int) []byte {
+	version := sf.version
+	if version == 0 {
+		version = seedFileVersion
+	}
+	buf := make([]byte, headerSize(version))
+	copy(buf, seedFileMagic)
+	binary.BigEndian.PutUint16(buf[8:], version)
+	binary.BigEndian.PutUint16(buf[10:], sf.flags)
+	binary.BigEndian.PutUint32(buf[12:], uint32(n))
+	binary.BigEndian.PutUint64(buf[16:], uint64(sf.created.UnixNano()))
+	binary.BigEndian.PutUint64(buf[24:], uint64(sf.updated.UnixNano()))
+	binary.BigEndian.PutUint64(buf[32:], sf.counter)
+	if version >= 2 {
+		copy(buf[40:], sf.host.machine[:])
+		copy(buf[56:], sf.host.boot[:])
+	}
+	return buf
+}
+
+// encode returns the on-disk representation of the seed file.
+func (sf *seedFileData) encode() []byte {
+	n := len(sf.payload)
+	buf := make([]byte, 0, headerSize(sf.version)+n+seedChecksumSize)
+	buf = append(buf, sf.header(n)...)
+	buf = append(buf, sf.payload...)
+	return append(buf, seedChecksum(buf)...)
+}
+
+// decodeSeedFile parses the contents of a seed file.  Legacy seed
+// files are recognised by their length and are returned with version
+// 0.  If the data is not a valid seed file, the returned error wraps
+// ErrCorruptedSeed or ErrSeedVersion and describes the problem.
+func decodeSeedFile(data []byte) (*seedFileData, error) {
+	if len(data) == seedFileSize && !bytes.HasPrefix(data, []byte(seedFileMagic)) {
+		if isZero(data) {
+			return nil, seedFormatError(ErrCorruptedSeed, "legacy seed is all zeros")
+		}
+		return &seedFileData{payload: data}, nil
+	}
+
+	if len(data) < len(seedFileMagic) ||
+		!bytes.Equal(data[:len(seedFileMagic)], []byte(seedFileMagic)) {
+		return nil, seedFormatError(ErrCorruptedSeed, "not a seed file (bad magic)")
+	}
+	if len(data) < seedHeaderSizeV1 {
+		return nil, seedFormatError(ErrCorruptedSeed, "truncated header")
+	}
+	version := binary.BigEndian.Uint16(data[8:])
+	if version < 1 || version > seedFileVersion {
+		return nil, seedFormatError(ErrSeedVersion, "unknown version %d", version)
+	}
+	h := headerSize(version)
+	if len(data) < h+seedChecksumSize {
+		return nil, seedFormatError(ErrCorruptedSeed, "truncated header")
+	}
+	n := int(binary.BigEndian.Uint32(data[12:]))
+	if n > maxSeedPayloadSize {
+		return nil, seedFormatError(ErrCorruptedSeed, "invalid payload length %d", n)
+	}
+	if len(data) != h+n+seedChecksumSize {
+		return nil, seedFormatError(ErrCorruptedSeed,
+			"file size %d does not match payload length %d", len(data), n)
+	}
+	body := data[:h+n]
+	if subtle.ConstantTimeCompare(seedChecksum(body), data[h+n:]) != 1 {
+		return nil, seedFormatError(ErrCorruptedSeed, "checksum mismatch")
+	}
+
+	sf := &seedFileData{
+		version: version,
+		flags:   binary.BigEndian.Uint16(data[10:]),
+		created: time.Unix(0, int64(binary.BigEndian.Uint64(data[16:]))),
+		updated: time.Unix(0, int64(binary.BigEndian.Uint64(data[24:]))),
+		counter: binary.BigEndian.Uint64(data[32:]),
+		payload: data[h : h+n],
+	}
+	if version >= 2 {
+		copy(sf.host.machine[:], data[40:56])
+		copy(sf.host.boot[:], data[56:72])
+	}
+	if sf.flags&^seedFlagEncrypted != 0 {
+		return nil, seedFormatError(ErrSeedVersion, "unknown flags 0x%04x", sf.flags)
+	}
+	if sf.flags&seedFlagEncrypted != 0 {
+		// the payload is checked when it is decrypted
+		return sf, nil
+	}
+	if len(sf.payload) != seedFileSize || isZero(sf.payload) {
+		return nil, seedFormatError(ErrCorruptedSeed, "invalid seed data")
+	}
+	return sf, nil
+}
@@ -0,0 +1,124 @@
+// seedformat_test.go - unit tests for seedformat.go
+// Copyright (C) 2013  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package fortuna
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+)
+
+func testSeedFileData() *seedFileData {
+	payload := make([]byte, seedFileSize)
+	for i := range payload {
+		payload[i] = byte(3*i + 1)
+	}
+	return &seedFileData{
+		created: time.Unix(1000, 1),
+		updated: time.Unix(2000, 2),
+		counter: 17,
+		payload: payload,
+	}
+}
+
+func TestSeedFileRoundTrip(t *testing.T) {
+	in := testSeedFileData()
+	data := in.encode()
+	if len(data) != seedHeaderSize+seedFileSize+seedChecksumSize {
+		t.Fatalf("wrong encoded length %d", len(data))
+	}
+
+	out, err := decodeSeedFile(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.version != seedFileVersion ||
+		!out.created.Equal(in.created) ||
+		!out.updated.Equal(in.updated) ||
+		out.counter != in.counter ||
+		bytes.Compare(out.payload, in.payload) != 0 {
+		t.Errorf("wrong round trip result %v", out)
+	}
+}
+
+func TestSeedFileLegacy(t *testing.T) {
+	legacy := testSeedFileData().payload
+	sf, err := decodeSeedFile(legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sf.version != 0 || bytes.Compare(sf.payload, legacy) != 0 {
+		t.Error("legacy seed file not read correctly")
+	}
+
+	_, err = decodeSeedFile(make([]byte, seedFileSize))
+	if !errors.Is(err, ErrCorruptedSeed) {
+		t.Error("all-zero legacy seed file not detected")
+	}
+}
+
+func TestSeedFileCorruption(t *testing.T) {
+	good := testSeedFileData().encode()
+
+	// every single bit flip must be detected
+	for i := range good {
+		for bit := uint(0); bit < 8; bit++ {
+			data := append([]byte{}, good...)
+			data[i] ^= 1 << bit
+			_, err := decodeSeedFile(data)
+			if err == nil {
+				t.Fatalf("bit %d of byte %d: corruption not detected", bit, i)
+			}
+		}
+	}
+
+	// so must truncation at any point
+	for n := 0; n < len(good); n++ {
+		_, err := decodeSeedFile(good[:n])
+		if n == seedFileSize {
+			// looks like a legacy file, which cannot be distinguished
+			continue
+		}
+		if !errors.Is(err, ErrCorruptedSeed) {
+			t.Errorf("truncation to %d bytes: got %v", n, err)
+		}
+	}
+
+	_, err := decodeSeedFile(append(good, 0))
+	if !errors.Is(err, ErrCorruptedSeed) {
+		t.Errorf("trailing garbage: got %v", err)
+	}
+
+	_, err = decodeSeedFile([]byte("Hello"))
+	if !errors.Is(err, ErrCorruptedSeed) {
+		t.Errorf("short file: got %v", err)
+	}
+}
+
+func TestSeedFileVersion(t *testing.T) {
+	data := testSeedFileData().encode()
+	binary.BigEndian.PutUint16(data[8:], seedFileVersion+1)
+	body := data[:len(data)-seedChecksumSize]
+	copy(data[len(body):], seedChecksum(body))
+
+	_, err := decodeSeedFile(data)
+	if !errors.Is(err, ErrSeedVersion) {
+		t.Errorf("unknown version not detected: %v", err)
+	}
+}
@@ -0,0 +1,400 @@
+// seedstore.go - persistent storage for seed data
+// Copyright (C) 2013  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package fortuna
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// SeedStore is the interface used by an Accumulator to keep seed
+// data between runs of a program.  The data passed to Store() is
+// opaque to the store; it is the complete contents of a seed file,
+// including header and checksum.
+//
+// An Accumulator calls Lock() once, before any other method.  After
+// a successful Lock(), the Accumulator owns the store and calls
+// Close() when the Accumulator is closed, or if the Accumulator
+// cannot be constructed.  If Lock() fails, Close() is not called.
+type SeedStore interface {
+	// Lock acquires exclusive use of the stored seed.  Seed data
+	// must never be shared between concurrently running instances
+	// of the random number generator, so Lock must fail if the
+	// seed is already in use.
+	Lock() error
+
+	// Load returns the stored seed data.  If no data has been
+	// stored yet, Load returns nil and no error.
+	Load() ([]byte, error)
+
+	// Store replaces the stored seed data.  After a crash, the store
+	// must contain either the old or the new data.
+	Store(data []byte) error
+
+	// Close releases the lock acquired by Lock and all other
+	// resources used by the store.
+	Close() error
+}
+
+// storeLogger is implemented by the seed stores of this package.
+// When a store is attached to an Accumulator, its log messages are
+// sent to the logger of the Accumulator, see WithLogger().
+type storeLogger interface {
+	setLogger(log *slog.Logger)
+}
+
+// errNotLocked is returned by stores which are used before Lock() is
+// called.
+var errNotLocked = errors.New("seed store not locked")
+
+// FileSeedStore is a SeedStore which keeps the seed in a single
+// file.  This is the store used by NewRNG() and WithSeedFile(); see
+// the documentation of NewRNG() for details.
+type FileSeedStore struct {
+	fileName string
+	lenient  bool
+	lock     *os.File
+	log      *slog.Logger
+}
+
+// NewFileSeedStore returns a SeedStore which keeps the seed in the
+// file fileName.  The policy determines how files with insecure
+// permissions or ownership are handled.
+func NewFileSeedStore(fileName string, policy SeedFilePolicy) *FileSeedStore {
+	return &FileSeedStore{
+		fileName: fileName,
+		lenient:  policy == SeedFileLenient,
+		log:      componentLogger(defaultLogger, "seed"),
+	}
+}
+
+// Lock locks the file with the suffix ".lock" next to the seed file.
+// The seed file itself is replaced on every update, so the lock is
+// held on a separate file which is never renamed.  Since the lock
+// file also holds the SeedMark, it is checked in the same way as the
+// seed file.
+func (s *FileSeedStore) Lock() error {
+	lock, err := openLockFile(s.fileName+seedLockSuffix, s.lenient, s.log)
+	if err != nil {
+		return err
+	}
+	err = flock(lock)
+	if err != nil {
+		lock.Close()
+		return err
+	}
+	s.lock = lock
+	return nil
+}
+
+// setLogger implements the storeLogger interface.
+func (s *FileSeedStore) setLogger(log *slog.Logger) {
+	s.log = log
+}
+
+// Load checks the permissions of the seed file and returns its
+// contents.  If the seed file does not exist, nil is returned.
+func (s *FileSeedStore) Load() ([]byte, error) {
+	err := checkSeedFile(s.fileName, s.lenient, s.log)
+	if err != nil {
+		return nil, err
+	}
+	return readSeedFile(s.fileName)
+}
+
+// Store atomically replaces the contents of the seed file.
+func (s *FileSeedStore) Store(data []byte) error {
+	err := doWriteSeed(s.fileName, data)
+	if err != nil {
+		return err
+	}
+	s.log.Info("new seed data written", logKeyPath, s.fileName)
+	return nil
+}
+
+// openLockFile opens or creates the lock file fileName.  Symbolic
+// links are not followed, and the file must be a regular file.  If
+// the permissions or ownership of the file are insecure, an
+// *InsecureSeedError is returned, unless lenient is true; in this case
+// the problem is only logged to log.
+func openLockFile(fileName string, lenient bool, log *slog.Logger) (*os.File, error) {
+	fi, err := os.Lstat(fileName)
+	if err == nil && fi.Mode()&os.ModeSymlink != 0 {
+		return nil, &InsecureSeedError{Path: fileName, Reason: "is a symbolic link"}
+	}
+	lock, err := os.OpenFile(fileName, os.O_RDWR|os.O_CREATE|openNoFollow,
+		os.FileMode(0600))
+	if err != nil {
+		return nil, err
+	}
+	fi, err = lock.Stat()
+	if err != nil {
+		lock.Close()
+		return nil, err
+	}
+	if !fi.Mode().IsRegular() {
+		lock.Close()
+		return nil, &InsecureSeedError{Path: fileName, Reason: "is not a regular file"}
+	}
+	if reason := insecureFile(fi); reason != "" {
+		if !lenient {
+			lock.Close()
+			return nil, &InsecureSeedError{Path: fileName, Reason: reason}
+		}
+		log.Error("insecure lock file", logKeyPath, fileName,
+			logKeyReason, reason)
+	}
+	return lock, nil
+}
+
+// LoadMark returns the mark recorded in the lock file.  Since the lock
+// file is stored next to the seed file, restoring both files from a
+// backup is not detected, see SeedCounter.  This implements the
+// SeedCounter interface.
+func (s *FileSeedStore) LoadMark() (SeedMark, error) {
+	var mark SeedMark
+	if s.lock == nil {
+		return mark, errNotLocked
+	}
+	var buf [8 + 16]byte
+	n, err := s.lock.ReadAt(buf[:], 0)
+	if err != nil && err != io.EOF {
+		return mark, err
+	}
+	if n < 8 {
+		// new lock file
+		return mark, nil
+	}
+	mark.Counter = binary.BigEndian.Uint64(buf[:8])
+	if n == len(buf) {
+		// Lock files written by older versions only hold the
+		// counter.
+		copy(mark.Boot[:], buf[8:])
+	}
+	return mark, nil
+}
+
+// StoreMark records the mark in the lock file.  This implements the
+// SeedCounter interface.
+func (s *FileSeedStore) StoreMark(mark SeedMark) error {
+	if s.lock == nil {
+		return errNotLocked
+	}
+	var buf [8 + 16]byte
+	binary.BigEndian.PutUint64(buf[:8], mark.Counter)
+	copy(buf[8:], mark.Boot[:])
+	_, err := s.lock.WriteAt(buf[:], 0)
+	if err != nil {
+		return err
+	}
+	return s.lock.Sync()
+}
+
+// Close releases the lock on the seed file.
+func (s *FileSeedStore) Close() error {
+	if s.lock == nil {
+		return nil
+	}
+	err := s.lock.Close()
+	s.lock = nil
+	return err
+}
+
+// maxSeedSlots is the maximal number of seed files used by a
+// DirSeedStore.
+const maxSeedSlots = 64
+
+// DirSeedStore is a SeedStore which keeps seed files in a directory.
+// Every Accumulator using the directory claims a separate seed file
+// "seed-00", "seed-01", ..., so that several instances of a program
+// can share one directory without sharing seed data.
+type DirSeedStore struct {
+	dir     string
+	policy  SeedFilePolicy
+	current *FileSeedStore
+	log     *slog.Logger
+}
+
+// NewDirSeedStore returns a SeedStore which keeps the seed in one of
+// the files in the directory dir.  The directory must exist.  The
+// policy determines how files with insecure permissions or ownership
+// are handled.
+func NewDirSeedStore(dir string, policy SeedFilePolicy) *DirSeedStore {
+	return &DirSeedStore{
+		dir:    dir,
+		policy: policy,
+		log:    componentLogger(defaultLogger, "seed"),
+	}
+}
+
+// setLogger implements the storeLogger interface.
+func (s *DirSeedStore) setLogger(log *slog.Logger) {
+	s.log = log
+}
+
+// Lock claims the first seed file in the directory which is not in
+// use by another Accumulator.  If all maxSeedSlots files are in use,
+// an error is returned.
+func (s *DirSeedStore) Lock() error {
+	for i := 0; i < maxSeedSlots; i++ {
+		fileName := filepath.Join(s.dir, fmt.Sprintf("seed-%02d", i))
+		store := NewFileSeedStore(fileName, s.policy)
+		store.log = s.log
+		err := store.Lock()
+		if err == nil {
+			s.current = store
+			return nil
+		} else if err != errAlreadyLocked {
+			return err
+		}
+	}
+	return fmt.Errorf("all %d seed files in %q are in use: %w",
+		maxSeedSlots, s.dir, errAlreadyLocked)
+}
+
+// FileName returns the name of the seed file claimed by Lock(), or
+// the empty string if the store is not locked.
+func (s *DirSeedStore) FileName() string {
+	if s.current == nil {
+		return ""
+	}
+	return s.current.fileName
+}
+
+// Load returns the contents of the claimed seed file.
+func (s *DirSeedStore) Load() ([]byte, error) {
+	if s.current == nil {
+		return nil, errNotLocked
+	}
+	return s.current.Load()
+}
+
+// Store atomically replaces the contents of the claimed seed file.
+func (s *DirSeedStore) Store(data []byte) error {
+	if s.current == nil {
+		return errNotLocked
+	}
+	return s.current.Store(data)
+}
+
+// LoadMark returns the mark recorded for the claimed seed file.  This
+// implements the SeedCounter interface.
+func (s *DirSeedStore) LoadMark() (SeedMark, error) {
+	if s.current == nil {
+		return SeedMark{}, errNotLocked
+	}
+	return s.current.LoadMark()
+}
+
+// StoreMark records the mark for the claimed seed file.  This
+// implements the SeedCounter interface.
+func (s *DirSeedStore) StoreMark(mark SeedMark) error {
+	if s.current == nil {
+		return errNotLocked
+	}
+	return s.current.StoreMark(mark)
+}
+
+// Close releases the claimed seed file.
+func (s *DirSeedStore) Close() error {
+	if s.current == nil {
+		return nil
+	}
+	err := s.current.Close()
+	s.current = nil
+	return err
+}
+
+// MemorySeedStore is a SeedStore which keeps the seed in memory.  The
+// stored data survives closing the Accumulator, so that a
+// MemorySeedStore can be used to pass seed data from one Accumulator
+// to the next within the same process.  This is mostly useful for
+// testing, and as an example for implementing custom stores.
+type MemorySeedStore struct {
+	mutex  sync.Mutex
+	locked bool
+	data   []byte
+	mark   SeedMark
+}
+
+// NewMemorySeedStore returns a new, empty MemorySeedStore.
+func NewMemorySeedStore() *MemorySeedStore {
+	return &MemorySeedStore{}
+}
+
+// Lock marks the store as in use.  If the store is already in use,
+// an error is returned.
+func (s *MemorySeedStore) Lock() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.locked {
+		return errAlreadyLocked
+	}
+	s.locked = true
+	return nil
+}
+
+// Load returns a copy of the stored data.
+func (s *MemorySeedStore) Load() ([]byte, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.data == nil {
+		return nil, nil
+	}
+	return append([]byte{}, s.data...), nil
+}
+
+// Store replaces the stored data by a copy of data.
+func (s *MemorySeedStore) Store(data []byte) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	wipe(s.data)
+	s.data = append([]byte{}, data...)
+	return nil
+}
+
+// LoadMark returns the last mark passed to StoreMark().  This
+// implements the SeedCounter interface.
+func (s *MemorySeedStore) LoadMark() (SeedMark, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.mark, nil
+}
+
+// StoreMark records the mark.  This implements the SeedCounter
+// interface.
+func (s *MemorySeedStore) StoreMark(mark SeedMark) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.mark = mark
+	return nil
+}
+
+// Close marks the store as no longer in use.  The stored data is
+// kept.
+func (s *MemorySeedStore) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.locked = false
+	return nil
+}
@@ -0,0 +1,153 @@
+// seedstore_test.go - unit tests for seedstore.go
+// Copyright (C) 2013  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package fortuna
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMemorySeedStore(t *testing.T) {
+	store := NewMemorySeedStore()
+
+	acc, err := NewAccumulatorWithOptions(WithSeedStore(store))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if acc.IsSeeded() {
+		t.Error("seeded from empty store")
+	}
+
+	// the store must not be shared
+	acc2, err := NewAccumulatorWithOptions(WithSeedStore(store))
+	if err == nil {
+		acc2.Close()
+		t.Error("shared seed store not detected")
+	}
+
+	err = acc.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, _ := store.Load()
+	if _, err := decodeSeedFile(first); err != nil {
+		t.Fatal(err)
+	}
+
+	acc, err = NewAccumulatorWithOptions(WithSeedStore(store))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !acc.IsSeeded() {
+		t.Error("not seeded from store")
+	}
+	acc.Close()
+	second, _ := store.Load()
+	if bytes.Compare(first, second) == 0 {
+		t.Error("seed not updated")
+	}
+}
+
+func TestDirSeedStore(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	store1 := NewDirSeedStore(tempDir, SeedFileStrict)
+	acc1, err := NewAccumulatorWithOptions(WithSeedStore(store1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store2 := NewDirSeedStore(tempDir, SeedFileStrict)
+	acc2, err := NewAccumulatorWithOptions(WithSeedStore(store2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	name1 := store1.FileName()
+	name2 := store2.FileName()
+	if name1 != filepath.Join(tempDir, "seed-00") || name1 == name2 {
+		t.Errorf("wrong seed files %q and %q", name1, name2)
+	}
+	acc1.Close()
+	acc2.Close()
+	if store1.FileName() != "" {
+		t.Error("seed file not released")
+	}
+
+	for _, name := range []string{name1, name2} {
+		data, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := decodeSeedFile(data); err != nil {
+			t.Error(err)
+		}
+	}
+
+	// the first free file is reused
+	store3 := NewDirSeedStore(tempDir, SeedFileStrict)
+	acc3, err := NewAccumulatorWithOptions(WithSeedStore(store3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if store3.FileName() != name1 || !acc3.IsSeeded() {
+		t.Error("seed file not reused")
+	}
+	acc3.Close()
+}
+
+type failingStore struct {
+	MemorySeedStore
+	closed bool
+}
+
+var errTestStore = errors.New("test store failure")
+
+func (s *failingStore) Store(data []byte) error {
+	return errTestStore
+}
+
+func (s *failingStore) Close() error {
+	s.closed = true
+	return s.MemorySeedStore.Close()
+}
+
+func TestSeedStoreFailure(t *testing.T) {
+	store := &failingStore{}
+	acc, err := NewAccumulatorWithOptions(WithSeedStore(store))
+	if err != errTestStore {
+		t.Errorf("store failure not reported: %v", err)
+	}
+	if acc != nil {
+		acc.Close()
+	}
+	if !store.closed {
+		t.Error("store not closed after failure")
+	}
+
+	_, err = NewAccumulatorWithOptions(WithSeedStore(NewMemorySeedStore()),
+		WithSeedFile("seed"))
+	if !errors.Is(err, ErrInvalidOption) {
+		t.Errorf("conflicting options not detected: %v", err)
+	}
+}
@@ -0,0 +1,115 @@
+// selftest_test.go - unit tests for selftest.go
+// Copyright (C) 2013  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package fortuna
+
+import (
+	"errors"
+	"testing"
+)
+
+// brokenPrimitive is a Primitive which ignores the key.
+type brokenPrimitive struct {
+	BLAKE2bXOF
+}
+
+func (brokenPrimitive) Name() string {
+	return "broken"
+}
+
+func (brokenPrimitive) NewStream(key []byte) Stream {
+	return BLAKE2bXOF{}.NewStream(nil)
+}
+
+func TestSelfTest(t *testing.T) {
+	for _, prim := range allPrimitives {
+		if err := selfTest(prim); err != nil {
+			t.Error(err)
+		}
+	}
+
+	err := selfTest(brokenPrimitive{})
+	if !errors.Is(err, ErrSelfTest) {
+		t.Error("broken primitive not detected")
+	}
+}
+
+func TestSelfTestFailure(t *testing.T) {
+	// simulate a tampered BLAKE2b implementation
+	saved := *blake2bKAT
+	defer func() { *blake2bKAT = saved }()
+	blake2bKAT.generator = "00" + blake2bKAT.generator[2:]
+
+	acc, err := NewAccumulatorWithOptions()
+	var serr *SelfTestError
+	if !errors.As(err, &serr) || serr.Test != "generator known answer" {
+		t.Error("wrong error:", err)
+	}
+	if acc != nil {
+		t.Error("Accumulator returned despite failed self-test")
+	}
+
+	gen, err := NewGeneratorChecked()
+	if !errors.As(err, &serr) || serr.Test != "generator known answer" {
+		t.Error("wrong error:", err)
+	}
+	if gen != nil {
+		t.Error("Generator returned despite failed self-test")
+	}
+
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("NewGenerator did not panic")
+		} else if err, _ := r.(error); !errors.Is(err, ErrSelfTest) {
+			t.Error("wrong panic value:", r)
+		}
+	}()
+	NewGenerator()
+}
+
+func TestTestVectors(t *testing.T) {
+	for _, vectors := range [][]testVector{aesVectors, blake2bVectors, chachaVectors} {
+		if name := checkVectors(vectors); name != "" {
+			t.Errorf("test vector %q failed", name)
+		}
+	}
+
+	// simulate a ChaCha20 implementation with the wrong nonce layout
+	saved := chachaVectors
+	defer func() { chachaVectors = saved }()
+	chachaVectors = []testVector{{"wrong nonce", func() []byte {
+		s := newChaChaStream(selfTestKey(), hexBytes("4a0000000000000000000000"), 1)
+		out := readStream(s, 16)
+		xorBytes(out, []byte("Ladies and Gentl"))
+		return out
+	}, saved[0].want[:32]}}
+	err := selfTest(ChaCha20{})
+	var serr *SelfTestError
+	if !errors.As(err, &serr) || serr.Test != "test vector wrong nonce" {
+		t.Error("wrong error:", err)
+	}
+}
+
+func TestNilPrimitive(t *testing.T) {
+	_, err := NewGeneratorChecked(WithPrimitive(nil))
+	if !errors.Is(err, ErrInvalidOption) {
+		t.Error("nil primitive not detected:", err)
+	}
+	_, err = NewAccumulatorWithOptions(WithGeneratorOptions(WithPrimitive(nil)))
+	if !errors.Is(err, ErrInvalidOption) {
+		t.Error("nil primitive not detected:", err)
+	}
+}
@@ -0,0 +1,174 @@
+// source.go - the registry of entropy sources
+// Copyright (C) 2013  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package fortuna
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrDuplicateSource is returned by NewSource() and
+// NewTimeStampSource() if an open entropy source with the same name
+// is already registered.
+var ErrDuplicateSource = errors.New("duplicate entropy source name")
+
+// SourceInfo describes an entropy source registered with an
+// Accumulator.
+type SourceInfo struct {
+	// ID is the number used to identify the source inside the
+	// entropy pools.  IDs are allocated consecutively and are never
+	// reused.
+	ID uint64
+
+	// Name is the name given to NewSource() or NewTimeStampSource().
+	// Sources created by NewEntropyDataSink() and
+	// NewEntropyTimeStampSink() have an empty name.
+	Name string
+
+	// Created is the time when the source was registered.
+	Created time.Time
+
+	// Closed is the time when the channel of the source was closed,
+	// or the zero time if the source is still open.  Since Sources()
+	// only lists open sources, Closed is only set in SourceEvents.
+	Closed time.Time
+
+	// Metadata holds the values set by WithSourceMetadata().
+	Metadata map[string]string
+
+	// Events is the number of events from this source which were
+	// mixed into the entropy pools, and Credit is the total entropy,
+	// in bits, credited for these events.  Events which failed a
+	// health test are not credited.
+	Events uint64
+	Credit float64
+}
+
+// source is the registry entry for one entropy source.
+type source struct {
+	SourceInfo
+}
+
+// info returns a copy of the information about src.  The caller must
+// hold acc.sourceMutex.
+func (src *source) info() SourceInfo {
+	res := src.SourceInfo
+	if src.Metadata != nil {
+		res.Metadata = make(map[string]string, len(src.Metadata))
+		for key, value := range src.Metadata {
+			res.Metadata[key] = value
+		}
+	}
+	return res
+}
+
+// WithSourceMetadata attaches descriptive key/value pairs to an
+// entropy source.  The metadata is not used by the Accumulator, but
+// is reported by Sources().  Repeated uses of the option are merged.
+func WithSourceMetadata(metadata map[string]string) SinkOption {
+	return func(cfg *sinkConfig) {
+		if cfg.metadata == nil {
+			cfg.metadata = make(map[string]string)
+		}
+		for key, value := range metadata {
+			cfg.metadata[key] = value
+		}
+	}
+}
+
+// registerSource allocates a new source ID and adds the source to the
+// registry.  If name is non-empty, it must not be used by another
+// open source.  On success, acc.sources is incremented; the caller
+// must start the goroutine which services the source and calls
+// acc.sources.Done() when it finishes.
+func (acc *Accumulator) registerSource(name string, cfg *sinkConfig) (*source, error) {
+	acc.sourceMutex.Lock()
+	defer acc.sourceMutex.Unlock()
+
+	// Close() sets sourcesStopped under sourceMutex before waiting
+	// for the sources, so no source can be added after the wait.
+	if acc.sourcesStopped {
+		return nil, ErrClosed
+	}
+
+	if name != "" {
+		if _, dup := acc.sourceNames[name]; dup {
+			return nil, fmt.Errorf("%w: %q", ErrDuplicateSource, name)
+		}
+	}
+
+	src := &source{
+		SourceInfo: SourceInfo{
+			ID:       acc.nextSource,
+			Name:     name,
+			Created:  acc.cfg.clock(),
+			Metadata: cfg.metadata,
+		},
+	}
+	acc.nextSource++
+	if acc.sourceNames == nil {
+		acc.sourceNames = make(map[string]*source)
+	}
+	if name != "" {
+		acc.sourceNames[name] = src
+	}
+	acc.sourceList = append(acc.sourceList, src)
+	acc.sources.Add(1)
+	acc.notify(sourceEvent(src))
+	return src, nil
+}
+
+// closeSource marks the source as closed and removes it from the
+// registry.  Only the totals of closed sources are kept, see Stats.
+// The name of the source can then be used for a new source, but the
+// ID is never reused.
+func (acc *Accumulator) closeSource(src *source) {
+	acc.sourceMutex.Lock()
+	defer acc.sourceMutex.Unlock()
+
+	src.Closed = acc.cfg.clock()
+	if src.Name != "" && acc.sourceNames[src.Name] == src {
+		delete(acc.sourceNames, src.Name)
+	}
+	for i, other := range acc.sourceList {
+		if other == src {
+			copy(acc.sourceList[i:], acc.sourceList[i+1:])
+			acc.sourceList[len(acc.sourceList)-1] = nil
+			acc.sourceList = acc.sourceList[:len(acc.sourceList)-1]
+			break
+		}
+	}
+	acc.closedSources++
+	acc.closedEvents += src.Events
+	acc.closedCredit += src.Credit
+	acc.notify(sourceEvent(src))
+}
+
+// Sources returns information about all open entropy sources of the
+// Accumulator, in the order of registration.  Closed sources are not
+// listed; their combined totals are reported by Stats().
+func (acc *Accumulator) Sources() []SourceInfo {
+	acc.sourceMutex.Lock()
+	defer acc.sourceMutex.Unlock()
+
+	res := make([]SourceInfo, len(acc.sourceList))
+	for i, src := range acc.sourceList {
+		res[i] = src.info()
+	}
+	return res
+}
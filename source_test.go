@@ -0,0 +1,156 @@
+// source_test.go - unit tests for source.go
+// Copyright (C) 2013  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package fortuna
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNewSource(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	acc, err := NewAccumulatorWithOptions(
+		WithSinkBufferSize(0),
+		WithClock(func() time.Time { return start }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer acc.Close()
+
+	http, err := acc.NewTimeStampSource("http-timing",
+		WithSourceMetadata(map[string]string{"port": "8080"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	acc.NewEntropyDataSink()
+	_, err = acc.NewSource("http-timing")
+	if !errors.Is(err, ErrDuplicateSource) {
+		t.Error("duplicate source name not detected:", err)
+	}
+	_, err = acc.NewSource("")
+	if !errors.Is(err, ErrInvalidOption) {
+		t.Error("empty source name not detected:", err)
+	}
+
+	sources := acc.Sources()
+	if len(sources) != 2 {
+		t.Fatal("wrong number of sources:", len(sources))
+	}
+	info := sources[0]
+	if info.ID != 0 || info.Name != "http-timing" || !info.Created.Equal(start) ||
+		!info.Closed.IsZero() || info.Metadata["port"] != "8080" {
+		t.Errorf("wrong source information: %+v", info)
+	}
+	info.Metadata["port"] = "0"
+	if acc.Sources()[0].Metadata["port"] != "8080" {
+		t.Error("metadata modified through Sources()")
+	}
+	if sources[1].ID != 1 || sources[1].Name != "" {
+		t.Errorf("wrong source information: %+v", sources[1])
+	}
+
+	// After the channel is closed, the name can be used again but
+	// the ID is not reused.
+	// Closed sources are removed from the list.
+	close(http)
+	for len(acc.Sources()) > 1 {
+		time.Sleep(time.Millisecond)
+	}
+	data, err := acc.NewSource("http-timing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data <- []byte{1, 2, 3}
+	sources = acc.Sources()
+	if len(sources) != 2 || sources[0].ID != 1 ||
+		sources[1].ID != 2 || sources[1].Name != "http-timing" {
+		t.Errorf("wrong source information: %+v", sources)
+	}
+	if stats := acc.Stats(); stats.ActiveSources != 2 || stats.ClosedSources != 1 {
+		t.Errorf("wrong number of sources: %+v", stats)
+	}
+
+	acc.Close()
+	if _, err := acc.NewSource("late"); err != ErrClosed {
+		t.Error("NewSource after Close did not fail:", err)
+	}
+	if sources := acc.Sources(); len(sources) != 0 {
+		t.Errorf("sources not closed by Close: %+v", sources)
+	}
+	stats := acc.Stats()
+	if stats.ActiveSources != 0 || stats.ClosedSources != 3 ||
+		stats.ClosedSourceEvents != 1 {
+		t.Errorf("wrong totals after Close: %+v", stats)
+	}
+}
+
+func TestSourceEncoding(t *testing.T) {
+	// Source IDs which agree modulo 256 must give different pool
+	// states.
+	var states []string
+	for _, source := range []uint64{1, 257, 1 << 40} {
+		acc, err := NewAccumulatorWithOptions(WithPools(1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		acc.addRandomEvent(source, 0, []byte{1, 2, 3, 4})
+		states = append(states, string(acc.pool[0]))
+		acc.Close()
+	}
+	for i := range states {
+		for j := i + 1; j < len(states); j++ {
+			if states[i] == states[j] {
+				t.Error("source IDs collide:", i, j)
+			}
+		}
+	}
+}
+
+func TestNewSourceDuringClose(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		acc, err := NewAccumulatorWithOptions()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var wg sync.WaitGroup
+		for j := 0; j < 4; j++ {
+			wg.Add(1)
+			go func(j int) {
+				defer wg.Done()
+				for k := 0; ; k++ {
+					_, err := acc.NewSource(fmt.Sprintf("source-%d-%d", j, k))
+					if err == ErrClosed {
+						return
+					}
+				}
+			}(j)
+		}
+		acc.Close()
+		// All sources registered before Close() returned have been
+		// stopped, and no source is registered afterwards.
+		if n := len(acc.Sources()); n != 0 {
+			t.Fatalf("%d sources still open after Close", n)
+		}
+		wg.Wait()
+		if n := len(acc.Sources()); n != 0 {
+			t.Fatalf("%d sources registered after Close", n)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+// stats.go - a snapshot of the internal state of an Accumulator
+// Copyright (C) 2013  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package fortuna
+
+import (
+	"time"
+)
+
+// Stats describes the state of an Accumulator at one point in time.
+// The values are meant for monitoring, to check that entropy is
+// flowing into the pools and that the seed file is kept up to date.
+// Stats never contain secret material.
+type Stats struct {
+	// Seeded is true once the generator has been seeded, see
+	// IsSeeded().
+	Seeded bool
+
+	// ReseedCount is the number of reseeds from the entropy pools,
+	// and LastReseed is the time of the last such reseed (or the zero
+	// time).  No reseed happens before NextReseed.
+	ReseedCount int
+	LastReseed  time.Time
+	NextReseed  time.Time
+
+	// Pools lists, for every entropy pool, the data which was added
+	// since the pool was last used for a reseed.
+	Pools []PoolStats
+
+	// OutputBytes is the number of bytes of random output generated
+	// since the last reseed from the entropy pools, and
+	// TotalOutputBytes is the number of bytes generated since the
+	// Accumulator was created.
+	OutputBytes      int64
+	TotalOutputBytes int64
+
+	// ActiveSources is the number of entropy sources which have not
+	// been closed.  Details about the sources are available from
+	// Sources().
+	ActiveSources int
+
+	// ClosedSources is the number of entropy sources which have been
+	// closed.  ClosedSourceEvents and ClosedSourceCredit are the
+	// totals of the Events and Credit fields of these sources, see
+	// SourceInfo.
+	ClosedSources      int
+	ClosedSourceEvents uint64
+	ClosedSourceCredit float64
+
+	// HealthFailures is the number of health test failures of
+	// entropy sources.  Every source fails at most once.
+	HealthFailures int
+
+	// Forks is the number of times a fork of the process was
+	// detected.
+	Forks int
+
+	// SeedFile describes the seed file or seed store.
+	SeedFile SeedFileStats
+}
+
+// PoolStats describes the contents of an entropy pool.
+type PoolStats struct {
+	// Bytes is the amount of event data added to the pool.
+	Bytes int64
+
+	// Entropy is the entropy credited to the pool, in bits.
+	Entropy float64
+}
+
+// SeedFileStats describes the state of the seed file or seed store of
+// an Accumulator.
+type SeedFileStats struct {
+	// Enabled is true if the Accumulator uses a seed file or seed
+	// store.  If Enabled is false, the remaining fields are zero.
+	Enabled bool
+
+	// Encrypted is true if the seed is stored encrypted.
+	Encrypted bool
+
+	// Counter is the write counter of the current seed file, and
+	// Created is the time when the seed file was first created.
+	Counter uint64
+	Created time.Time
+
+	// LastSaved is the time of the last successful write, see
+	// LastSaved().
+	LastSaved time.Time
+
+	// UnsavedReseeds and UnsavedBytes give the number of reseeds and
+	// bytes of output since the seed was last written.
+	UnsavedReseeds int
+	UnsavedBytes   int64
+
+	// LastError is the error from the last write attempt of the
+	// autosave mechanism, or nil if this write succeeded.
+	// FailedSaves is the total number of failed write attempts of
+	// the autosave mechanism.
+	LastError   error
+	FailedSaves int
+}
+
+// Stats returns a snapshot of the internal state of the Accumulator.
+// The different parts of the snapshot are collected one after another
+// and may be slightly inconsistent if the Accumulator is in use while
+// Stats() is called.
+func (acc *Accumulator) Stats() *Stats {
+	res := &Stats{
+		Seeded: acc.IsSeeded(),
+	}
+
+	acc.poolMutex.Lock()
+	res.ReseedCount = acc.reseedCount
+	res.LastReseed = acc.lastReseed
+	res.NextReseed = acc.nextReseed
+	res.Pools = make([]PoolStats, len(acc.pool))
+	for i := range res.Pools {
+		res.Pools[i] = PoolStats{
+			Bytes:   acc.poolBytes[i],
+			Entropy: acc.poolEntropy[i],
+		}
+	}
+	acc.poolMutex.Unlock()
+
+	acc.sourceMutex.Lock()
+	res.HealthFailures = acc.healthFails
+	res.ActiveSources = len(acc.sourceList)
+	res.ClosedSources = acc.closedSources
+	res.ClosedSourceEvents = acc.closedEvents
+	res.ClosedSourceCredit = acc.closedCredit
+	acc.sourceMutex.Unlock()
+
+	acc.saveMutex.Lock()
+	enabled := acc.store != nil
+	acc.saveMutex.Unlock()
+
+	acc.genMutex.Lock()
+	res.OutputBytes = acc.outputBytes
+	res.TotalOutputBytes = acc.totalBytes
+	res.Forks = acc.forks
+	if enabled {
+		res.SeedFile = SeedFileStats{
+			Enabled:        true,
+			Encrypted:      acc.encrypted(),
+			Counter:        acc.seedInfo.counter,
+			Created:        acc.seedInfo.created,
+			LastSaved:      acc.lastSaved,
+			UnsavedReseeds: acc.unsavedReseeds,
+			UnsavedBytes:   acc.unsavedBytes,
+			LastError:      acc.autoSaveErr,
+			FailedSaves:    acc.autoSaveFails,
+		}
+	}
+	acc.genMutex.Unlock()
+
+	return res
+}
@@ -0,0 +1,115 @@
+// stats_test.go - unit tests for stats.go
+// Copyright (C) 2013  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package fortuna
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// brokenSeedStore is a MemorySeedStore where writes can be made to
+// fail.
+type brokenSeedStore struct {
+	*MemorySeedStore
+
+	mutex sync.Mutex
+	err   error
+}
+
+func (s *brokenSeedStore) Store(data []byte) error {
+	s.mutex.Lock()
+	err := s.err
+	s.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+	return s.MemorySeedStore.Store(data)
+}
+
+func TestStats(t *testing.T) {
+	now := time.Unix(1000000000, 0)
+	store := &brokenSeedStore{MemorySeedStore: NewMemorySeedStore()}
+	acc, err := NewAccumulatorWithOptions(
+		WithPools(4),
+		WithSeedStore(store),
+		WithClock(func() time.Time { return now }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer acc.Close()
+
+	stats := acc.Stats()
+	if stats.Seeded || stats.ReseedCount != 0 || len(stats.Pools) != 4 ||
+		stats.ActiveSources != 0 || stats.OutputBytes != 0 {
+		t.Errorf("wrong stats for new Accumulator: %+v", stats)
+	}
+	seed := stats.SeedFile
+	if !seed.Enabled || seed.Encrypted || seed.Counter != 1 ||
+		!seed.Created.Equal(now) || !seed.LastSaved.Equal(now) || seed.LastError != nil {
+		t.Errorf("wrong seed file stats: %+v", seed)
+	}
+
+	acc.addRandomEvent(0, 1, []byte{1, 2, 3})
+	acc.NewEntropyDataSink()
+	stats = acc.Stats()
+	if stats.Pools[1].Bytes != 3 || stats.Pools[1].Entropy != 8*(2+3) ||
+		stats.Pools[0].Bytes != 0 || stats.ActiveSources != 1 {
+		t.Errorf("wrong stats after event: %+v", stats)
+	}
+
+	// The first reseed triggers a write to the seed store, which
+	// fails.
+	store.mutex.Lock()
+	store.err = errTestStore
+	store.mutex.Unlock()
+	now = now.Add(time.Second)
+	acc.addRandomEvent(0, 0, make([]byte, 32))
+	acc.RandomData(100)
+	acc.addRandomEvent(0, 4, make([]byte, 32))
+	for i := 0; i < 100 && acc.Stats().SeedFile.LastError == nil; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	stats = acc.Stats()
+	if !stats.Seeded || stats.ReseedCount != 1 || !stats.LastReseed.Equal(now) ||
+		!stats.NextReseed.Equal(now.Add(minReseedInterval)) ||
+		stats.OutputBytes != 100 || stats.TotalOutputBytes != 100 {
+		t.Errorf("wrong stats after reseed: %+v", stats)
+	}
+	if stats.Pools[0].Bytes != 32 || stats.Pools[0].Entropy != 8*34 ||
+		stats.Pools[1].Bytes != 3 {
+		t.Errorf("wrong pool stats after reseed: %+v", stats.Pools)
+	}
+	seed = stats.SeedFile
+	if seed.LastError != errTestStore || seed.FailedSaves != 1 ||
+		seed.UnsavedReseeds != 1 || seed.UnsavedBytes != 100 {
+		t.Errorf("wrong seed file stats after failed write: %+v", seed)
+	}
+}
+
+func TestStatsWithoutSeedFile(t *testing.T) {
+	acc, err := NewAccumulatorWithOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer acc.Close()
+
+	if seed := acc.Stats().SeedFile; seed != (SeedFileStats{}) {
+		t.Errorf("wrong seed file stats: %+v", seed)
+	}
+}